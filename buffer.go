@@ -0,0 +1,27 @@
+package gomasio
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize keeps a few huge messages from pinning memory in the pool.
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orisano/gomasio"
+	"github.com/orisano/gomasio/engineio"
+	"github.com/orisano/gomasio/socketio"
+)
+
+type multiFlag []string
+
+func (f *multiFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *multiFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+type worker struct {
+	url        string
+	connOpts   []gomasio.ConnOption
+	eioOpts    []engineio.Option
+	steps      []step
+	ackTimeout time.Duration
+	stats      *stats
+}
+
+func (w *worker) run(ctx context.Context) {
+	w.stats.attempt()
+	begin := time.Now()
+	conn, err := gomasio.NewConn(w.url, w.connOpts...)
+	if err != nil {
+		w.stats.fail(fmt.Errorf("dial: %w", err))
+		return
+	}
+
+	connected := make(chan socketio.Context, 1)
+	ptm := socketio.NewPacketTypeMux()
+	ptm.HandleFunc(socketio.CONNECT, func(sctx socketio.Context) {
+		if sctx.Namespace() != "/" {
+			return
+		}
+		select {
+		case connected <- sctx:
+		default:
+		}
+	})
+	ptm.HandleFunc(socketio.EVENT, func(socketio.Context) {
+		w.stats.receive()
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- socketio.Connect(ctx, conn, ptm, w.eioOpts...)
+	}()
+
+	var sctx socketio.Context
+	select {
+	case sctx = <-connected:
+		w.stats.connect(time.Since(begin))
+	case err := <-errc:
+		if err == nil {
+			err = errors.New("closed before connect")
+		}
+		w.stats.fail(err)
+		conn.Close()
+		return
+	case <-ctx.Done():
+		w.stats.fail(errors.New("not connected before the end of test"))
+		conn.Close()
+		<-errc
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.script(ctx, sctx)
+	}()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			err = errors.New("closed by server")
+		}
+		w.stats.drop(err)
+		cancel()
+		<-done
+		conn.Close()
+	case <-ctx.Done():
+		<-done
+		conn.Close()
+		<-errc
+	}
+}
+
+func (w *worker) script(ctx context.Context, sctx socketio.Context) {
+	for {
+		for _, s := range w.steps {
+			switch s.kind {
+			case stepSleep:
+				t := time.NewTimer(s.sleep)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return
+				}
+			case stepEmit:
+				if err := sctx.Emit(s.event, s.args...); err != nil {
+					return
+				}
+				w.stats.emit()
+			case stepAck:
+				actx, cancel := context.WithTimeout(ctx, w.ackTimeout)
+				start := time.Now()
+				_, err := socketio.EmitWithAck(sctx, actx, s.event, s.args...)
+				cancel()
+				w.stats.emit()
+				switch {
+				case err == nil:
+					w.stats.ack(time.Since(start))
+				case ctx.Err() != nil:
+					return
+				case errors.Is(err, context.DeadlineExceeded):
+					w.stats.ackTimeout()
+				default:
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+func run() error {
+	var conns int
+	flag.IntVar(&conns, "conns", 10, "target connection count")
+
+	var ramp time.Duration
+	flag.DurationVar(&ramp, "ramp", 0, "ramp-up period over which connections are started")
+
+	var duration time.Duration
+	flag.DurationVar(&duration, "duration", 30*time.Second, "test duration including ramp-up")
+
+	var scriptPath string
+	flag.StringVar(&scriptPath, "script", "", "emit script file run by every connection (default: emit message every second)")
+
+	var ackTimeout time.Duration
+	flag.DurationVar(&ackTimeout, "ack-timeout", 5*time.Second, "ack timeout of ack steps")
+
+	var secure bool
+	flag.BoolVar(&secure, "secure", false, "use wss")
+
+	var path string
+	flag.StringVar(&path, "path", "/socket.io/", "socket.io path")
+
+	var eio int
+	flag.IntVar(&eio, "eio", 0, "engine.io protocol version (3 or 4, 0 detects it; use 3 for socket.io 2.x servers)")
+
+	var headers, queries multiFlag
+	flag.Var(&headers, "H", "request header `name: value` (repeatable)")
+	flag.Var(&queries, "query", "query parameter `key=value` (repeatable)")
+
+	var jsonPath string
+	flag.StringVar(&jsonPath, "json", "", "write JSON report to this file (- for stdout)")
+
+	var scalable bool
+	flag.BoolVar(&scalable, "scalable", false, "share writer goroutines, heartbeat timers and handler goroutines among connections")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] host\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Script lines are `emit event [json-arg ...]`, `ack event [json-arg ...]` or `sleep duration`. A script must sleep.")
+		fmt.Fprintln(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return errors.New("missing host")
+	}
+	if conns <= 0 {
+		return errors.New("conns must be positive")
+	}
+	if eio != 0 && eio != 3 && eio != 4 {
+		return fmt.Errorf("unsupported engine.io version: %v", eio)
+	}
+
+	script := strings.NewReader(defaultScript)
+	if scriptPath != "" {
+		b, err := ioutil.ReadFile(scriptPath)
+		if err != nil {
+			return fmt.Errorf("read script: %w", err)
+		}
+		script = strings.NewReader(string(b))
+	}
+	steps, err := parseScript(script)
+	if err != nil {
+		return fmt.Errorf("parse script: %w", err)
+	}
+
+	urlOpts := []gomasio.URLOption{gomasio.WithPath(path)}
+	if secure {
+		urlOpts = append(urlOpts, gomasio.WithSecure)
+	}
+	if eio != 0 {
+		urlOpts = append(urlOpts, gomasio.SetQuery("EIO", strconv.Itoa(eio)))
+	}
+	for _, q := range queries {
+		kv := strings.SplitN(q, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid query: %v", q)
+		}
+		urlOpts = append(urlOpts, gomasio.SetQuery(kv[0], kv[1]))
+	}
+	u, err := gomasio.GetURL(flag.Arg(0), urlOpts...)
+	if err != nil {
+		return fmt.Errorf("get url: %w", err)
+	}
+	h := make(http.Header)
+	for _, x := range headers {
+		kv := strings.SplitN(x, ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid header: %v", x)
+		}
+		h.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	connOpts := []gomasio.ConnOption{gomasio.WithHeader(h)}
+	if eio == 0 {
+		connOpts = append(connOpts, gomasio.WithAutoVersion)
+	}
+	var eioOpts []engineio.Option
+	if scalable {
+		procs := runtime.GOMAXPROCS(0)
+		sched := gomasio.NewWriteScheduler(2 * procs)
+		defer sched.Close()
+		wheel := engineio.NewTimingWheel(100*time.Millisecond, 1024)
+		defer wheel.Stop()
+		pool := engineio.NewDispatchPool(4*procs, 1024)
+		defer pool.Close()
+		connOpts = append(connOpts, gomasio.WithWriteScheduler(sched), gomasio.WithQueueSize(8))
+		eioOpts = append(eioOpts, engineio.WithClock(wheel), engineio.WithDispatcher(pool))
+	}
+
+	st := newStats()
+	w := &worker{
+		url:        u.String(),
+		connOpts:   connOpts,
+		eioOpts:    eioOpts,
+		steps:      steps,
+		ackTimeout: ackTimeout,
+		stats:      st,
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < conns && ctx.Err() == nil; i++ {
+		t := time.NewTimer(time.Until(start.Add(ramp * time.Duration(i) / time.Duration(conns))))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx)
+		}()
+	}
+	<-ctx.Done()
+	wg.Wait()
+
+	r := st.report(conns, time.Since(start))
+	switch jsonPath {
+	case "":
+		r.writeSummary(os.Stdout)
+	case "-":
+		return r.writeJSON(os.Stdout)
+	default:
+		r.writeSummary(os.Stdout)
+		f, err := os.Create(jsonPath)
+		if err != nil {
+			return fmt.Errorf("create report: %w", err)
+		}
+		defer f.Close()
+		if err := r.writeJSON(f); err != nil {
+			return fmt.Errorf("write report: %w", err)
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type stepKind int
+
+const (
+	stepEmit stepKind = iota
+	stepAck
+	stepSleep
+)
+
+type step struct {
+	kind  stepKind
+	event string
+	args  []interface{}
+	sleep time.Duration
+}
+
+const defaultScript = `emit message "hello"
+sleep 1s
+`
+
+// parseScript reads lines of `emit event [json-arg ...]`, `ack event [json-arg ...]` or `sleep duration`.
+func parseScript(r io.Reader) ([]step, error) {
+	var steps []step
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		st, err := parseStep(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", n, err)
+		}
+		steps = append(steps, st)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty script")
+	}
+	// The script is repeated without pause, so a script without sleep would emit in a busy loop.
+	var sleep time.Duration
+	for _, st := range steps {
+		sleep += st.sleep
+	}
+	if sleep <= 0 {
+		return nil, fmt.Errorf("script must sleep")
+	}
+	return steps, nil
+}
+
+func parseStep(line string) (step, error) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "sleep":
+		if len(fields) != 2 {
+			return step{}, fmt.Errorf("sleep requires a duration")
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return step{}, err
+		}
+		return step{kind: stepSleep, sleep: d}, nil
+	case "emit", "ack":
+		if len(fields) < 2 {
+			return step{}, fmt.Errorf("%v requires an event name", fields[0])
+		}
+		st := step{kind: stepEmit, event: fields[1]}
+		if fields[0] == "ack" {
+			st.kind = stepAck
+		}
+		rest := strings.TrimSpace(line[len(fields[0]):])
+		dec := json.NewDecoder(strings.NewReader(rest[len(fields[1]):]))
+		for {
+			var arg json.RawMessage
+			err := dec.Decode(&arg)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return step{}, fmt.Errorf("parse args: %w", err)
+			}
+			st.args = append(st.args, arg)
+		}
+		return st, nil
+	default:
+		return step{}, fmt.Errorf("unknown command: %v", fields[0])
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/orisano/gomasio/internal/histogram"
+)
+
+type bucketReport struct {
+	LE    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+type histogramReport struct {
+	Count   int64          `json:"count"`
+	Min     string         `json:"min"`
+	Mean    string         `json:"mean"`
+	P50     string         `json:"p50"`
+	P90     string         `json:"p90"`
+	P99     string         `json:"p99"`
+	Max     string         `json:"max"`
+	Buckets []bucketReport `json:"buckets"`
+}
+
+func newHistogramReport(s histogram.Snapshot) *histogramReport {
+	r := &histogramReport{
+		Count: s.Count,
+		Min:   s.Min.String(),
+		Mean:  s.Mean().String(),
+		P50:   s.Quantile(0.5).String(),
+		P90:   s.Quantile(0.9).String(),
+		P99:   s.Quantile(0.99).String(),
+		Max:   s.Max.String(),
+	}
+	for i, c := range s.Counts {
+		if c == 0 {
+			continue
+		}
+		r.Buckets = append(r.Buckets, bucketReport{LE: histogram.Label(i), Count: c})
+	}
+	return r
+}
+
+type stats struct {
+	mu sync.Mutex
+
+	attempted   int
+	connected   int
+	failed      int
+	dropped     int
+	emits       int64
+	acks        int64
+	ackTimeouts int64
+	received    int64
+	errors      map[string]int
+
+	connectLatency *histogram.Histogram
+	ackLatency     *histogram.Histogram
+}
+
+func newStats() *stats {
+	return &stats{
+		errors:         make(map[string]int),
+		connectLatency: histogram.New(),
+		ackLatency:     histogram.New(),
+	}
+}
+
+func (s *stats) attempt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempted++
+}
+
+func (s *stats) connect(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected++
+	s.connectLatency.Observe(d)
+}
+
+func (s *stats) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed++
+	s.errors[err.Error()]++
+}
+
+func (s *stats) drop(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped++
+	s.errors[err.Error()]++
+}
+
+func (s *stats) emit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emits++
+}
+
+func (s *stats) ack(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acks++
+	s.ackLatency.Observe(d)
+}
+
+func (s *stats) ackTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackTimeouts++
+}
+
+func (s *stats) receive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received++
+}
+
+type report struct {
+	Elapsed     string  `json:"elapsed"`
+	Target      int     `json:"target"`
+	Attempted   int     `json:"attempted"`
+	Connected   int     `json:"connected"`
+	Failed      int     `json:"failed"`
+	Dropped     int     `json:"dropped"`
+	SuccessRate float64 `json:"success_rate"`
+
+	Emits       int64 `json:"emits"`
+	Acks        int64 `json:"acks"`
+	AckTimeouts int64 `json:"ack_timeouts"`
+	Received    int64 `json:"received"`
+
+	ConnectLatency *histogramReport `json:"connect_latency"`
+	AckLatency     *histogramReport `json:"ack_latency"`
+
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+func (s *stats) report(target int, elapsed time.Duration) *report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &report{
+		Elapsed:        elapsed.String(),
+		Target:         target,
+		Attempted:      s.attempted,
+		Connected:      s.connected,
+		Failed:         s.failed,
+		Dropped:        s.dropped,
+		Emits:          s.emits,
+		Acks:           s.acks,
+		AckTimeouts:    s.ackTimeouts,
+		Received:       s.received,
+		ConnectLatency: newHistogramReport(s.connectLatency.Snapshot()),
+		AckLatency:     newHistogramReport(s.ackLatency.Snapshot()),
+		Errors:         make(map[string]int, len(s.errors)),
+	}
+	if s.attempted > 0 {
+		r.SuccessRate = float64(s.connected) / float64(s.attempted)
+	}
+	for k, v := range s.errors {
+		r.Errors[k] = v
+	}
+	return r
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *report) writeSummary(w io.Writer) {
+	fmt.Fprintf(w, "elapsed:      %v\n", r.Elapsed)
+	fmt.Fprintf(w, "connections:  %v/%v connected, %v failed, %v dropped (success rate %.2f%%)\n", r.Connected, r.Attempted, r.Failed, r.Dropped, r.SuccessRate*100)
+	fmt.Fprintf(w, "emits:        %v sent, %v acked, %v ack timeouts, %v events received\n", r.Emits, r.Acks, r.AckTimeouts, r.Received)
+	writeHistogram(w, "connect:", r.ConnectLatency)
+	writeHistogram(w, "ack rtt:", r.AckLatency)
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, "errors:")
+		keys := make([]string, 0, len(r.Errors))
+		for k := range r.Errors {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %6d %v\n", r.Errors[k], k)
+		}
+	}
+}
+
+func writeHistogram(w io.Writer, name string, h *histogramReport) {
+	fmt.Fprintf(w, "%-13s n=%v min=%v mean=%v p50=%v p90=%v p99=%v max=%v\n", name, h.Count, h.Min, h.Mean, h.P50, h.P90, h.P99, h.Max)
+	for _, b := range h.Buckets {
+		fmt.Fprintf(w, "  <= %-8v %v\n", b.LE, b.Count)
+	}
+}
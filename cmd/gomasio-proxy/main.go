@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/orisano/gomasio/engineio"
+	"github.com/orisano/gomasio/socketio"
+)
+
+type direction string
+
+const (
+	toServer direction = "C->S"
+	toClient direction = "S->C"
+)
+
+type frame struct {
+	engineType engineio.PacketType
+	socketType socketio.PacketType
+	isMessage  bool
+	binary     bool
+	namespace  string
+	id         int
+	event      string
+	payload    []byte
+	err        error
+}
+
+func inspect(b []byte) *frame {
+	f := &frame{id: -1}
+	p, err := engineio.NewDecoder(bytes.NewReader(b)).Decode()
+	if err != nil {
+		f.err = fmt.Errorf("decode engine.io packet: %w", err)
+		return f
+	}
+	f.engineType = p.Type
+	if p.Type != engineio.MESSAGE {
+		f.payload, _ = ioutil.ReadAll(p.Body)
+		return f
+	}
+	f.isMessage = true
+	sp, err := socketio.NewDecoder(p.Body).Decode()
+	if err != nil {
+		f.err = fmt.Errorf("decode socket.io packet: %w", err)
+		return f
+	}
+	f.socketType = sp.Type
+	f.namespace = sp.Namespace
+	f.id = sp.ID
+	f.payload, _ = ioutil.ReadAll(sp.Body)
+	if sp.Type == socketio.EVENT || sp.Type == socketio.BINARY_EVENT {
+		var e socketio.Event
+		if err := json.Unmarshal(f.payload, &e); err != nil {
+			f.err = fmt.Errorf("decode event: %w", err)
+			return f
+		}
+		f.event = e.Name
+		args, _ := json.Marshal(e.Args)
+		f.payload = args
+	}
+	return f
+}
+
+// inspectBinary decodes a binary frame, which is a MESSAGE without the packet type on protocol 4,
+// and a packet headed by its type byte before that. The body is a socket.io attachment.
+func inspectBinary(b []byte, version int) *frame {
+	f := &frame{id: -1, binary: true}
+	if version >= 4 {
+		f.engineType = engineio.MESSAGE
+		f.payload = b
+		return f
+	}
+	p, err := engineio.NewDecoder(bytes.NewReader(b)).DecodeBinary()
+	if err != nil {
+		f.err = fmt.Errorf("decode engine.io binary packet: %w", err)
+		return f
+	}
+	f.engineType = p.Type
+	f.payload, _ = ioutil.ReadAll(p.Body)
+	return f
+}
+
+type filter struct {
+	namespace     string
+	event         *regexp.Regexp
+	drop          *regexp.Regexp
+	showHeartbeat bool
+	raw           bool
+}
+
+func (f *filter) visible(fr *frame) bool {
+	if fr.binary {
+		return f.namespace == "" && f.event == nil
+	}
+	if !fr.isMessage {
+		return f.showHeartbeat || (fr.engineType != engineio.PING && fr.engineType != engineio.PONG)
+	}
+	if f.namespace != "" && fr.namespace != f.namespace {
+		return false
+	}
+	if f.event != nil && !f.event.MatchString(fr.event) {
+		return false
+	}
+	return true
+}
+
+func (f *filter) dropped(fr *frame) bool {
+	return f.drop != nil && fr.isMessage && fr.event != "" && f.drop.MatchString(fr.event)
+}
+
+type printer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (p *printer) print(session int, dir direction, b []byte, fr *frame, note string, raw bool) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v #%v %v", time.Now().Format("15:04:05.000"), session, dir)
+	if note != "" {
+		fmt.Fprintf(&sb, " [%v]", note)
+	}
+	switch {
+	case fr.err != nil:
+		fmt.Fprintf(&sb, " error=%q", fr.err)
+	case fr.binary:
+		fmt.Fprintf(&sb, " engine.io=%v binary %v bytes", fr.engineType, len(fr.payload))
+	default:
+		fmt.Fprintf(&sb, " engine.io=%v", fr.engineType)
+		if fr.isMessage {
+			fmt.Fprintf(&sb, " socket.io=%v ns=%v", fr.socketType, fr.namespace)
+			if fr.id >= 0 {
+				fmt.Fprintf(&sb, " id=%v", fr.id)
+			}
+			if fr.event != "" {
+				fmt.Fprintf(&sb, " event=%q", fr.event)
+			}
+		}
+	}
+	sb.WriteByte('\n')
+	if len(fr.payload) > 0 {
+		var out bytes.Buffer
+		if err := json.Indent(&out, fr.payload, "  ", "  "); err == nil {
+			fmt.Fprintf(&sb, "  %s\n", out.Bytes())
+		} else {
+			fmt.Fprintf(&sb, "  %q\n", fr.payload)
+		}
+	}
+	if raw {
+		fmt.Fprintf(&sb, "  raw: %q\n", b)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, sb.String())
+}
+
+type peer struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func (p *peer) write(mt int, b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ws.WriteMessage(mt, b)
+}
+
+type session struct {
+	id      int
+	version int
+	client  *peer
+	server  *peer
+}
+
+type proxy struct {
+	upstream *url.URL
+	dialer   *websocket.Dialer
+	upgrader websocket.Upgrader
+	filter   *filter
+	printer  *printer
+
+	mu       sync.Mutex
+	nextID   int
+	sessions map[int]*session
+}
+
+var skipHeaders = map[string]bool{
+	"Connection":               true,
+	"Upgrade":                  true,
+	"Host":                     true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Only the websocket transport is proxied. Polling requests are rejected before dialing upstream.
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "gomasio-proxy supports only the websocket transport (set transports: [\"websocket\"] on the client)", http.StatusBadRequest)
+		return
+	}
+	target := *p.upstream
+	target.Path = r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+
+	h := make(http.Header)
+	for k, v := range r.Header {
+		if !skipHeaders[http.CanonicalHeaderKey(k)] {
+			h[k] = v
+		}
+	}
+	sws, resp, err := p.dialer.Dial(target.String(), h)
+	if err != nil {
+		status := http.StatusBadGateway
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		log.Printf("dial upstream %v: %v", target.String(), err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer sws.Close()
+	cws, err := p.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("upgrade: %v", err)
+		return
+	}
+	defer cws.Close()
+
+	p.mu.Lock()
+	s := &session{id: p.nextID, version: 3, client: &peer{ws: cws}, server: &peer{ws: sws}}
+	if r.URL.Query().Get("EIO") == "4" {
+		s.version = 4
+	}
+	p.nextID++
+	p.sessions[s.id] = s
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.sessions, s.id)
+		p.mu.Unlock()
+	}()
+	log.Printf("session #%v: %v", s.id, target.String())
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- p.pump(s, toServer, s.client.ws, s.server)
+	}()
+	go func() {
+		errc <- p.pump(s, toClient, s.server.ws, s.client)
+	}()
+	err = <-errc
+	log.Printf("session #%v closed: %v", s.id, err)
+}
+
+func (p *proxy) pump(s *session, dir direction, src *websocket.Conn, dst *peer) error {
+	for {
+		mt, b, err := src.ReadMessage()
+		if err != nil {
+			forwardClose(dst, err)
+			return err
+		}
+		var fr *frame
+		if mt == websocket.TextMessage {
+			fr = inspect(b)
+		} else {
+			fr = inspectBinary(b, s.version)
+		}
+		drop := p.filter.dropped(fr)
+		if p.filter.visible(fr) {
+			note := ""
+			if drop {
+				note = "DROP"
+			}
+			p.printer.print(s.id, dir, b, fr, note, p.filter.raw)
+		}
+		if drop {
+			continue
+		}
+		if err := dst.write(mt, b); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardClose passes the close of one peer, which ended its read with err, on to the other peer.
+// A close frame is forwarded with its code and text. A peer gone without a close frame is closed likewise.
+func forwardClose(dst *peer, err error) {
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code == websocket.CloseAbnormalClosure || ce.Code == websocket.CloseTLSHandshake {
+		dst.ws.Close()
+		return
+	}
+	msg := []byte{}
+	// CloseNoStatusReceived means an empty close frame, which must not be sent with the code.
+	if ce.Code != websocket.CloseNoStatusReceived {
+		msg = websocket.FormatCloseMessage(ce.Code, ce.Text)
+	}
+	dst.write(websocket.CloseMessage, msg)
+}
+
+// inject reads lines of `>frame` (to server) or `<frame` (to client) and sends them to every session.
+func (p *proxy) inject(r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if len(line) < 2 || (line[0] != '>' && line[0] != '<') {
+			log.Printf("invalid inject line(expected >frame or <frame): %q", line)
+			continue
+		}
+		b := []byte(line[1:])
+		dir := toServer
+		if line[0] == '<' {
+			dir = toClient
+		}
+
+		p.mu.Lock()
+		sessions := make([]*session, 0, len(p.sessions))
+		for _, x := range p.sessions {
+			sessions = append(sessions, x)
+		}
+		p.mu.Unlock()
+
+		for _, x := range sessions {
+			dst := x.server
+			if dir == toClient {
+				dst = x.client
+			}
+			p.printer.print(x.id, dir, b, inspect(b), "INJECT", p.filter.raw)
+			if err := dst.write(websocket.TextMessage, b); err != nil {
+				log.Printf("inject #%v: %v", x.id, err)
+			}
+		}
+	}
+}
+
+func run() error {
+	var addr string
+	flag.StringVar(&addr, "addr", "localhost:8081", "listen address")
+
+	var upstream string
+	flag.StringVar(&upstream, "upstream", "", "upstream socket.io server (ws://host:port or wss://host:port)")
+
+	var namespace string
+	flag.StringVar(&namespace, "ns", "", "show only packets of this namespace")
+
+	var event string
+	flag.StringVar(&event, "event", "", "show only events whose name matches this regexp")
+
+	var drop string
+	flag.StringVar(&drop, "drop", "", "drop events whose name matches this regexp")
+
+	var showHeartbeat bool
+	flag.BoolVar(&showHeartbeat, "heartbeat", false, "show engine.io ping/pong")
+
+	var raw bool
+	flag.BoolVar(&raw, "raw", false, "show raw frames")
+
+	var inject bool
+	flag.BoolVar(&inject, "inject", false, "read frames to inject from stdin (>frame to server, <frame to client)")
+
+	flag.Parse()
+	if upstream == "" {
+		flag.Usage()
+		return errors.New("missing upstream")
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return fmt.Errorf("parse upstream: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return fmt.Errorf("unsupported upstream scheme: %v", u.Scheme)
+	}
+
+	f := &filter{
+		namespace:     namespace,
+		showHeartbeat: showHeartbeat,
+		raw:           raw,
+	}
+	if event != "" {
+		if f.event, err = regexp.Compile(event); err != nil {
+			return fmt.Errorf("compile event filter: %w", err)
+		}
+	}
+	if drop != "" {
+		if f.drop, err = regexp.Compile(drop); err != nil {
+			return fmt.Errorf("compile drop filter: %w", err)
+		}
+	}
+
+	p := &proxy{
+		upstream: u,
+		dialer: &websocket.Dialer{
+			Proxy: http.ProxyFromEnvironment,
+		},
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		filter:   f,
+		printer:  &printer{w: os.Stdout},
+		sessions: make(map[int]*session),
+	}
+	if inject {
+		go p.inject(os.Stdin)
+	}
+	log.Printf("listening on %v, forwarding to %v", addr, u.String())
+	return http.ListenAndServe(addr, p)
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orisano/gomasio"
+	"github.com/orisano/gomasio/engineio"
+	"github.com/orisano/gomasio/socketio"
+)
+
+type multiFlag []string
+
+func (f *multiFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *multiFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+type message struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	Namespace string            `json:"ns,omitempty"`
+	Event     string            `json:"event,omitempty"`
+	Args      []json.RawMessage `json:"args,omitempty"`
+	Data      string            `json:"data,omitempty"`
+	Latency   string            `json:"latency,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type output struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (o *output) print(m *message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m.Time = time.Now()
+	o.enc.Encode(m)
+}
+
+type client struct {
+	out *output
+
+	mu         sync.Mutex
+	namespaces map[string]*namespace
+}
+
+// namespace holds the context of the CONNECT packet of a namespace, which emits in the namespace.
+type namespace struct {
+	connected chan struct{}
+	ctx       socketio.Context
+}
+
+func newClient(w io.Writer) *client {
+	return &client{
+		out:        &output{enc: json.NewEncoder(w)},
+		namespaces: make(map[string]*namespace),
+	}
+}
+
+func (c *client) namespace(ns string) *namespace {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.namespaces[ns]
+	if !ok {
+		n = &namespace{connected: make(chan struct{})}
+		c.namespaces[ns] = n
+	}
+	return n
+}
+
+func (c *client) context(ns string) socketio.Context {
+	n := c.namespace(ns)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return n.ctx
+}
+
+func (c *client) HandleSocketIO(ctx socketio.Context) {
+	m := &message{
+		Type:      strings.ToLower(ctx.PacketType().String()),
+		Namespace: ctx.Namespace(),
+	}
+	switch ctx.PacketType() {
+	case socketio.CONNECT:
+		n := c.namespace(ctx.Namespace())
+		c.mu.Lock()
+		if n.ctx == nil {
+			close(n.connected)
+		}
+		n.ctx = ctx
+		c.mu.Unlock()
+	case socketio.EVENT:
+		m.Event = ctx.Event()
+		args := ctx.(socketio.ArgAccessor)
+		if err := args.ScanArgs(); err != nil {
+			m.Error = err.Error()
+			break
+		}
+		for i := 0; i < args.ArgCount(); i++ {
+			m.Args = append(m.Args, args.Arg(i))
+		}
+	case socketio.ACK:
+		// Acks reach the handler only after their EmitWithAck has given up.
+		var args []json.RawMessage
+		if err := json.NewDecoder(ctx.Body()).Decode(&args); err != nil {
+			m.Error = err.Error()
+			break
+		}
+		m.Args = args
+	case socketio.ERROR:
+		m.Error = socketio.Err(ctx).Error()
+	default:
+		b, _ := ioutil.ReadAll(ctx.Body())
+		m.Data = string(b)
+	}
+	c.out.print(m)
+}
+
+func (c *client) join(ctx context.Context, ns string, timeout time.Duration) error {
+	if ns != "/" {
+		if err := socketio.ConnectNamespace(c.context("/"), ns, nil); err != nil {
+			return err
+		}
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-c.namespace(ns).connected:
+		return nil
+	case <-t.C:
+		return fmt.Errorf("connect timeout(namespace=%v)", ns)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *client) emit(ctx context.Context, ns string, e *socketio.Event, ack time.Duration) error {
+	sctx := c.context(ns)
+	if sctx == nil {
+		c.out.print(&message{Type: "invalid", Namespace: ns, Event: e.Name, Error: "namespace is not connected"})
+		return nil
+	}
+	args := make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg
+	}
+	if ack <= 0 {
+		return sctx.Emit(e.Name, args...)
+	}
+
+	actx, cancel := context.WithTimeout(ctx, ack)
+	defer cancel()
+	start := time.Now()
+	res, err := socketio.EmitWithAck(sctx, actx, e.Name, args...)
+	switch {
+	case err == nil:
+		c.out.print(&message{Type: "ack", Namespace: ns, Event: e.Name, Args: res, Latency: time.Since(start).String()})
+	case ctx.Err() != nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		c.out.print(&message{Type: "ack", Namespace: ns, Event: e.Name, Error: "timeout"})
+	default:
+		return err
+	}
+	return nil
+}
+
+func parseLine(line, ns string) (string, *socketio.Event, error) {
+	if strings.HasPrefix(line, "/") {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return "", nil, fmt.Errorf("missing event name")
+		}
+		ns = line[:i]
+		line = strings.TrimSpace(line[i:])
+	}
+
+	e := &socketio.Event{}
+	rest := ""
+	if i := strings.IndexAny(line, " \t"); i < 0 {
+		e.Name = line
+	} else {
+		e.Name = line[:i]
+		rest = line[i:]
+	}
+	dec := json.NewDecoder(strings.NewReader(rest))
+	for {
+		var arg json.RawMessage
+		err := dec.Decode(&arg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("parse args: %w", err)
+		}
+		e.Args = append(e.Args, arg)
+	}
+	return ns, e, nil
+}
+
+func (c *client) script(ctx context.Context, r io.Reader, ns string, ack time.Duration) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ns, e, err := parseLine(line, ns)
+		if err != nil {
+			c.out.print(&message{Type: "invalid", Data: line, Error: err.Error()})
+			continue
+		}
+		if err := c.emit(ctx, ns, e, ack); err != nil {
+			return fmt.Errorf("emit: %w", err)
+		}
+	}
+	return s.Err()
+}
+
+func run() error {
+	var headers, cookies, queries, namespaces multiFlag
+	flag.Var(&headers, "H", "request header `name: value` (repeatable)")
+	flag.Var(&cookies, "cookie", "request cookie `name=value` (repeatable)")
+	flag.Var(&queries, "query", "query parameter `key=value` (repeatable)")
+	flag.Var(&namespaces, "ns", "namespace to join (repeatable)")
+
+	var secure bool
+	flag.BoolVar(&secure, "secure", false, "use wss")
+
+	var path string
+	flag.StringVar(&path, "path", "/socket.io/", "socket.io path")
+
+	var eio int
+	flag.IntVar(&eio, "eio", 0, "engine.io protocol version (3 or 4, 0 detects it; use 3 for socket.io 2.x servers)")
+
+	var ack time.Duration
+	flag.DurationVar(&ack, "ack", 0, "wait for ack up to this duration on each emit (0 disables acks)")
+
+	var connectTimeout time.Duration
+	flag.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "namespace connect timeout")
+
+	var linger time.Duration
+	flag.DurationVar(&linger, "linger", -1, "keep listening for this duration after stdin is closed (negative waits forever)")
+
+	var verbose bool
+	flag.BoolVar(&verbose, "v", false, "log connection lifecycle to stderr")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] url\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "url is a host or a URL such as https://example.com/namespace?token=x.")
+		fmt.Fprintln(flag.CommandLine.Output(), "Each line of stdin is emitted as `[/namespace] event [json-arg ...]` (the namespace defaults to that of url).")
+		fmt.Fprintln(flag.CommandLine.Output(), "Incoming packets are printed as JSON lines.")
+		fmt.Fprintln(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return errors.New("missing url")
+	}
+
+	urlOpts := []gomasio.URLOption{gomasio.WithPath(path)}
+	if secure {
+		urlOpts = append(urlOpts, gomasio.WithSecure)
+	}
+	if eio != 0 {
+		urlOpts = append(urlOpts, gomasio.SetQuery("EIO", strconv.Itoa(eio)))
+	}
+	for _, q := range queries {
+		kv := strings.SplitN(q, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid query: %v", q)
+		}
+		urlOpts = append(urlOpts, gomasio.SetQuery(kv[0], kv[1]))
+	}
+	u, namespace, err := gomasio.ParseURL(flag.Arg(0), urlOpts...)
+	if err != nil {
+		return fmt.Errorf("parse url: %w", err)
+	}
+	if namespace != "/" {
+		namespaces = append(namespaces, namespace)
+	}
+
+	h := make(http.Header)
+	for _, x := range headers {
+		kv := strings.SplitN(x, ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid header: %v", x)
+		}
+		h.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if len(cookies) > 0 {
+		// A request carries all of its cookies in a single Cookie header (RFC 6265 section 5.4).
+		h.Set("Cookie", strings.Join(append(h.Values("Cookie"), cookies...), "; "))
+	}
+
+	logger := gomasio.NopLogger()
+	if verbose {
+		logger = gomasio.StdLogger(log.New(os.Stderr, "", log.LstdFlags))
+	}
+
+	connOpts := []gomasio.ConnOption{gomasio.WithHeader(h), gomasio.WithLogger(logger)}
+	if eio == 0 {
+		connOpts = append(connOpts, gomasio.WithAutoVersion)
+	}
+	conn, err := gomasio.NewConn(u.String(), connOpts...)
+	if err != nil {
+		return fmt.Errorf("create connection: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	c := newClient(os.Stdout)
+	errc := make(chan error, 2)
+	go func() {
+		errc <- socketio.Connect(ctx, conn, c, engineio.WithLogger(logger))
+	}()
+	go func() {
+		for _, ns := range append([]string{"/"}, namespaces...) {
+			if err := c.join(ctx, ns, connectTimeout); err != nil {
+				errc <- err
+				return
+			}
+		}
+		if err := c.script(ctx, os.Stdin, namespace, ack); err != nil {
+			errc <- err
+			return
+		}
+		if linger >= 0 {
+			time.Sleep(linger)
+			errc <- nil
+		}
+	}()
+
+	select {
+	case err = <-errc:
+	case <-sig:
+	}
+	cancel()
+	conn.Close()
+	return err
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
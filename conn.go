@@ -2,9 +2,17 @@ package gomasio
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -18,22 +26,105 @@ type WriterFactory interface {
 	NewWriter() WriteFlusher
 }
 
+var (
+	ErrMessageTooLarge = errors.New("message exceeds read limit")
+	ErrClosed          = errors.New("use of closed connection")
+	ErrQueueFull       = errors.New("write queue is full")
+)
+
+// TryFlusher is implemented by writers that can flush without waiting for room in the write queue.
+type TryFlusher interface {
+	// TryFlush is like Flush, but fails with ErrQueueFull instead of blocking. The message is dropped on failure.
+	TryFlush() error
+}
+
+// TryFlush flushes w without blocking if w is a TryFlusher, and by Flush otherwise.
+func TryFlush(w WriteFlusher) error {
+	if f, ok := w.(TryFlusher); ok {
+		return f.TryFlush()
+	}
+	return w.Flush()
+}
+
+// Discarder is implemented by writers that hold a buffer until Flush.
+type Discarder interface {
+	// Discard drops the message written so far instead of sending it and releases its buffer.
+	Discard()
+}
+
+// Discard drops the message written to w if w is a Discarder. Otherwise w is left as is.
+func Discard(w WriteFlusher) {
+	if d, ok := w.(Discarder); ok {
+		d.Discard()
+	}
+}
+
 type Conn interface {
 	WriterFactory
 	NextReader() (io.Reader, error)
 	Close() error
 }
 
+// CloseNotifier is implemented by connections that report their closure.
+type CloseNotifier interface {
+	// Done returns a channel that is closed when the connection is closed.
+	Done() <-chan struct{}
+	// Err returns nil until the connection is closed. After that it returns ErrClosed if Close is called,
+	// or the error that broke the connection.
+	Err() error
+}
+
+// Done returns a channel that is closed when c is closed. It returns nil if c is not a CloseNotifier.
+func Done(c Conn) <-chan struct{} {
+	if n, ok := c.(CloseNotifier); ok {
+		return n.Done()
+	}
+	return nil
+}
+
 // ref: https://godoc.org/github.com/gorilla/websocket#hdr-Concurrency
 type conn struct {
-	ws  *websocket.Conn
-	wch chan io.Reader
+	ws      *websocket.Conn
+	pending [][]byte
+	wch     chan outMessage
+	logger  Logger
+	metrics Metrics
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+
+	sched     *WriteScheduler
+	scheduled int32
+	// next links c in the ready list of sched.
+	next *conn
+
+	writeTimeout time.Duration
+
+	// queued is the queue depth last reported to metrics.
+	queueMu sync.Mutex
+	queued  int
+
+	version int
 }
 
 type ConnOptions struct {
 	QueueSize uint
 	Header    http.Header
 	Dialer    *websocket.Dialer
+	Logger    Logger
+	Metrics   Metrics
+
+	PollingHandshake bool
+	HandshakeTimeout time.Duration
+	AutoVersion      bool
+
+	Compression      bool
+	CompressionLevel int
+	ReadLimit        int64
+
+	WriteScheduler *WriteScheduler
+	WriteTimeout   time.Duration
 }
 
 type ConnOption func(o *ConnOptions)
@@ -56,76 +147,531 @@ func WithCookieJar(jar http.CookieJar) ConnOption {
 	}
 }
 
+// WithPollingHandshake performs the engine.io handshake over HTTP long-polling and then
+// upgrades the session to websocket, as the JavaScript client does. Cookies set by the
+// handshake response (e.g. sticky session cookies of load balancers) are sent on the upgrade.
+func WithPollingHandshake(o *ConnOptions) {
+	o.PollingHandshake = true
+}
+
+// WithAutoVersion detects the engine.io protocol version of the server instead of using the EIO parameter of the URL.
+// It requests EIO=4 first, and the connection is of protocol 4 if the server accepts it with an OPEN packet.
+// It reconnects with EIO=3 if the server answers 400 Bad Request or closes the websocket before sending OPEN.
+// Other failures, such as timeouts, are returned as is. The detected version is reported by ProtocolVersion.
+// engine.io 3 servers (socket.io 2.x) that accept any EIO are detected as protocol 4, so request EIO=3 for them.
+func WithAutoVersion(o *ConnOptions) {
+	o.AutoVersion = true
+}
+
+// WithCompression negotiates permessage-deflate and compresses outgoing messages with level (see compress/flate).
+func WithCompression(level int) ConnOption {
+	return func(o *ConnOptions) {
+		o.Compression = true
+		o.CompressionLevel = level
+	}
+}
+
+// WithReadLimit limits the size of incoming messages. Reading a larger message fails with ErrMessageTooLarge.
+func WithReadLimit(n int64) ConnOption {
+	return func(o *ConnOptions) {
+		o.ReadLimit = n
+	}
+}
+
+// WithDialer replaces the websocket dialer. Options that configure the dialer must come after it.
+func WithDialer(d *websocket.Dialer) ConnOption {
+	return func(o *ConnOptions) {
+		dialer := *d
+		o.Dialer = &dialer
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of wss connections. It also applies to the polling handshake over https.
+func WithTLSConfig(c *tls.Config) ConnOption {
+	return func(o *ConnOptions) {
+		o.Dialer.TLSClientConfig = c
+	}
+}
+
+// WithProxy sets the function choosing the proxy of a request, as http.Transport.Proxy does.
+// The default is http.ProxyFromEnvironment. It also applies to the polling handshake.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) ConnOption {
+	return func(o *ConnOptions) {
+		o.Dialer.Proxy = proxy
+	}
+}
+
+// WithHandshakeTimeout limits the time to establish the connection including the polling handshake and the upgrade.
+func WithHandshakeTimeout(d time.Duration) ConnOption {
+	return func(o *ConnOptions) {
+		o.HandshakeTimeout = d
+	}
+}
+
+// WithWriteScheduler writes messages on the goroutines of s instead of a goroutine per connection.
+func WithWriteScheduler(s *WriteScheduler) ConnOption {
+	return func(o *ConnOptions) {
+		o.WriteScheduler = s
+	}
+}
+
+// WithWriteTimeout limits the time to write a message. A write that does not finish in time breaks the connection,
+// so that a stalled peer cannot hold a writer goroutine forever. The default is 10 seconds, and d <= 0 disables it.
+func WithWriteTimeout(d time.Duration) ConnOption {
+	return func(o *ConnOptions) {
+		o.WriteTimeout = d
+	}
+}
+
+func WithLogger(l Logger) ConnOption {
+	return func(o *ConnOptions) {
+		o.Logger = l
+	}
+}
+
+func WithMetrics(m Metrics) ConnOption {
+	return func(o *ConnOptions) {
+		o.Metrics = m
+	}
+}
+
+// HandshakeError is returned when the server rejects the handshake.
+type HandshakeError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	msg := fmt.Sprintf("%v: %v", e.Err, e.Status)
+	if len(e.Body) > 0 {
+		msg += ": " + string(e.Body)
+	}
+	return msg
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+const maxErrorBodySize = 4096
+
+func newHandshakeError(resp *http.Response, err error) error {
+	if resp == nil {
+		return err
+	}
+	var body []byte
+	if resp.Body != nil {
+		body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	}
+	return &HandshakeError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       bytes.TrimSpace(body),
+		Err:        err,
+	}
+}
+
 func NewConn(urlStr string, opts ...ConnOption) (Conn, error) {
+	return NewConnContext(context.Background(), urlStr, opts...)
+}
+
+func NewConnContext(ctx context.Context, urlStr string, opts ...ConnOption) (Conn, error) {
 	options := &ConnOptions{
 		QueueSize: 100,
 		Header:    nil,
 		Dialer: &websocket.Dialer{
 			Proxy: http.ProxyFromEnvironment,
 		},
+		Logger:       NopLogger(),
+		Metrics:      NopMetrics(),
+		WriteTimeout: 10 * time.Second,
 	}
 	for _, opt := range opts {
 		opt(options)
 	}
 
-	ws, _, err := options.Dialer.Dial(urlStr, options.Header)
+	logger := options.Logger
+	metrics := options.Metrics
+	if options.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.HandshakeTimeout)
+		defer cancel()
+	}
+	version := queryVersion(urlStr)
+	var ws *websocket.Conn
+	var pending [][]byte
+	var err error
+	if options.AutoVersion {
+		ws, pending, version, err = dialAuto(ctx, urlStr, options)
+	} else {
+		ws, pending, err = dial(ctx, urlStr, options)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	wch := make(chan io.Reader, options.QueueSize)
-	go func() {
-		for r := range wch {
-			wc, err := ws.NextWriter(websocket.TextMessage)
+	c := &conn{
+		ws:      ws,
+		pending: pending,
+		wch:     make(chan outMessage, options.QueueSize),
+		logger:  logger,
+		metrics: metrics,
+		done:    make(chan struct{}),
+		sched:   options.WriteScheduler,
+
+		writeTimeout: options.WriteTimeout,
+		version:      version,
+	}
+	if c.sched == nil {
+		go c.writeLoop()
+	}
+	return c, nil
+}
+
+func dial(ctx context.Context, urlStr string, options *ConnOptions) (*websocket.Conn, [][]byte, error) {
+	logger := options.Logger
+	var pending [][]byte
+	if options.PollingHandshake {
+		dialer := *options.Dialer
+		if dialer.Jar == nil {
+			jar, err := cookiejar.New(nil)
 			if err != nil {
-				continue
+				return nil, nil, fmt.Errorf("create cookie jar: %w", err)
 			}
-			if _, err := io.Copy(wc, r); err != nil {
-				continue
+			dialer.Jar = jar
+		}
+		options.Dialer = &dialer
+		transport := pollingTransport(&dialer)
+		client := &http.Client{
+			Jar:       dialer.Jar,
+			Transport: transport,
+		}
+		logger.Debug("engine.io polling handshake", "url", urlStr)
+		u, packets, err := pollingHandshake(ctx, client, urlStr, options.Header)
+		// The transport is used only for the handshake. Closing its idle connections stops their goroutines.
+		transport.CloseIdleConnections()
+		if err != nil {
+			return nil, nil, err
+		}
+		urlStr = u
+		pending = packets
+	}
+
+	logger.Debug("dial websocket", "url", urlStr)
+	dialer := options.Dialer
+	if options.Compression {
+		d := *dialer
+		d.EnableCompression = true
+		dialer = &d
+	}
+	ws, resp, err := dialer.DialContext(ctx, urlStr, options.Header)
+	if err != nil {
+		return nil, nil, newHandshakeError(resp, err)
+	}
+	if options.ReadLimit > 0 {
+		ws.SetReadLimit(options.ReadLimit)
+	}
+	if options.Compression {
+		ws.EnableWriteCompression(true)
+		if err := ws.SetCompressionLevel(options.CompressionLevel); err != nil {
+			ws.Close()
+			return nil, nil, fmt.Errorf("set compression level: %w", err)
+		}
+	}
+	if options.PollingHandshake {
+		if err := upgradeProbe(ctx, ws); err != nil {
+			ws.Close()
+			return nil, nil, err
+		}
+	}
+	logger.Debug("websocket connected", "url", urlStr)
+	return ws, pending, nil
+}
+
+func dialAuto(ctx context.Context, urlStr string, options *ConnOptions) (*websocket.Conn, [][]byte, int, error) {
+	u, err := withVersion(urlStr, 4)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	ws, pending, err := dial(ctx, u, options)
+	if err == nil && len(pending) == 0 {
+		var b []byte
+		if b, err = readOpen(ctx, ws); err != nil {
+			ws.Close()
+		}
+		pending = [][]byte{b}
+	}
+	if rejectedVersion(err) {
+		options.Logger.Info("engine.io protocol 4 rejected, falling back to 3", "error", err)
+		if u, err = withVersion(urlStr, 3); err != nil {
+			return nil, nil, 0, err
+		}
+		ws, pending, err = dial(ctx, u, options)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return ws, pending, 3, nil
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if err := checkOpen(pending[0]); err != nil {
+		ws.Close()
+		return nil, nil, 0, err
+	}
+	options.Logger.Debug("engine.io protocol detected", "version", 4)
+	return ws, pending, 4, nil
+}
+
+// rejectedVersion reports whether err means that the server does not speak the requested protocol version:
+// either it answered the handshake with 400 Bad Request, or it closed the websocket before sending OPEN.
+func rejectedVersion(err error) bool {
+	var he *HandshakeError
+	if errors.As(err, &he) {
+		return he.StatusCode == http.StatusBadRequest
+	}
+	var ce *websocket.CloseError
+	return errors.As(err, &ce)
+}
+
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case m := <-c.wch:
+			c.reportQueueDepth()
+			if err := c.write(m); err != nil {
+				c.logger.Error("write websocket message", "error", err)
+				c.closeWithError(err)
+				return
 			}
-			wc.Close()
+		case <-c.done:
+			return
 		}
-	}()
-	return &conn{
-		ws:  ws,
-		wch: wch,
-	}, nil
+	}
+}
+
+func (c *conn) write(m outMessage) error {
+	defer putBuffer(m.buf)
+	if c.writeTimeout > 0 {
+		if err := c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return fmt.Errorf("set write deadline: %w", err)
+		}
+	}
+	wc, err := c.ws.NextWriter(int(m.mt))
+	if err != nil {
+		return fmt.Errorf("get websocket writer: %w", err)
+	}
+	n, err := wc.Write(m.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write websocket message: %w", err)
+	}
+	c.metrics.BytesSent(n)
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("flush websocket message: %w", err)
+	}
+	return nil
 }
 
 func (c *conn) NextReader() (io.Reader, error) {
-	mt, r, err := c.ws.NextReader()
+	mt, r, err := c.NextMessage()
 	if err != nil {
 		return nil, err
 	}
-	if mt != websocket.TextMessage {
+	if mt != TextMessage {
+		c.logger.Warn("unsupported websocket message type", "type", mt)
 		return nil, fmt.Errorf("currently supports only text message: %v", mt)
 	}
 	return r, nil
 }
 
+func (c *conn) NextMessage() (MessageType, io.Reader, error) {
+	if len(c.pending) > 0 {
+		b := c.pending[0]
+		c.pending = c.pending[1:]
+		c.metrics.BytesReceived(len(b))
+		return TextMessage, bytes.NewReader(b), nil
+	}
+	select {
+	case <-c.done:
+		return 0, nil, c.Err()
+	default:
+	}
+	mt, r, err := c.ws.NextReader()
+	if err != nil {
+		if errors.Is(err, websocket.ErrReadLimit) {
+			err = ErrMessageTooLarge
+		}
+		c.closeWithError(err)
+		return 0, nil, c.Err()
+	}
+	return MessageType(mt), &countReader{r: r, c: c}, nil
+}
+
 func (c *conn) NewWriter() WriteFlusher {
-	return &asyncWriter{q: c.wch, buf: &bytes.Buffer{}}
+	return c.newWriter(TextMessage)
+}
+
+func (c *conn) NewMessageWriter(mt MessageType) (WriteFlusher, error) {
+	return c.newWriter(mt), nil
+}
+
+func (c *conn) newWriter(mt MessageType) WriteFlusher {
+	return &asyncWriter{c: c, mt: mt}
+}
+
+// ProtocolVersion returns the engine.io protocol version requested by the URL or detected by WithAutoVersion.
+func (c *conn) ProtocolVersion() int {
+	return c.version
+}
+
+// SetReadDeadline sets the deadline of the pending and future reads. A read after the deadline fails.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
 }
 
+// Close closes the connection. It is safe to call Close concurrently with writes and more than once.
 func (c *conn) Close() error {
-	close(c.wch)
-	return c.ws.Close()
+	return c.closeWithError(ErrClosed)
+}
+
+func (c *conn) closeWithError(reason error) error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.logger.Debug("close websocket", "reason", reason)
+		c.err = reason
+		close(c.done)
+		err = c.ws.Close()
+	})
+	c.reportQueueDepth()
+	return err
+}
+
+// reportQueueDepth reports the change of the queue depth since the last report.
+// The messages left in the queue of a closed connection are not counted.
+func (c *conn) reportQueueDepth() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	n := len(c.wch)
+	select {
+	case <-c.done:
+		n = 0
+	default:
+	}
+	if n != c.queued {
+		c.metrics.QueueDepthAdd(n - c.queued)
+		c.queued = n
+	}
+}
+
+func (c *conn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *conn) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+type outMessage struct {
+	mt  MessageType
+	buf *bytes.Buffer
 }
 
 type asyncWriter struct {
-	q   chan<- io.Reader
+	c   *conn
+	mt  MessageType
 	buf *bytes.Buffer
 }
 
 func (w *asyncWriter) Write(p []byte) (n int, err error) {
+	if w.buf == nil {
+		w.buf = getBuffer()
+	}
 	return w.buf.Write(p)
 }
 
+// Flush queues the message. It returns ErrClosed if the connection is closed.
+// The writer can be reused after Flush.
 func (w *asyncWriter) Flush() error {
-	w.q <- w.buf
+	return w.flush(true)
+}
+
+// TryFlush queues the message unless the queue is full.
+func (w *asyncWriter) TryFlush() error {
+	return w.flush(false)
+}
+
+func (w *asyncWriter) Discard() {
+	if w.buf != nil {
+		putBuffer(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *asyncWriter) flush(block bool) error {
+	c := w.c
+	buf := w.buf
+	if buf == nil {
+		buf = getBuffer()
+	}
+	w.buf = nil
+	select {
+	case <-c.done:
+		putBuffer(buf)
+		return ErrClosed
+	default:
+	}
+	m := outMessage{mt: w.mt, buf: buf}
+	if block {
+		select {
+		case c.wch <- m:
+		case <-c.done:
+			putBuffer(buf)
+			return ErrClosed
+		}
+	} else {
+		select {
+		case c.wch <- m:
+		case <-c.done:
+			putBuffer(buf)
+			return ErrClosed
+		default:
+			putBuffer(buf)
+			return ErrQueueFull
+		}
+	}
+	c.reportQueueDepth()
+	if c.sched != nil {
+		c.sched.schedule(c)
+	}
 	return nil
 }
 
+type countReader struct {
+	r io.Reader
+	c *conn
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if n > 0 {
+		r.c.metrics.BytesReceived(n)
+	}
+	if errors.Is(err, websocket.ErrReadLimit) {
+		r.c.closeWithError(ErrMessageTooLarge)
+		err = ErrMessageTooLarge
+	}
+	return n, err
+}
+
 type nopFlusher struct {
 	w io.Writer
 }
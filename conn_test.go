@@ -0,0 +1,269 @@
+package gomasio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConn_Close(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := GetURL(strings.TrimPrefix(ts.URL, "http://"))
+	conn, err := NewConn(u.String(), WithQueueSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				w := conn.NewWriter()
+				io.WriteString(w, "4hello")
+				if err := w.Flush(); err != nil {
+					if !errors.Is(err, ErrClosed) {
+						t.Errorf("unexpected flush error. expected: %v, but got: %v", ErrClosed, err)
+					}
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		conn.Close()
+	}
+	wg.Wait()
+
+	select {
+	case <-Done(conn):
+	default:
+		t.Error("done channel is not closed")
+	}
+	if err := conn.(CloseNotifier).Err(); !errors.Is(err, ErrClosed) {
+		t.Errorf("unexpected close reason. expected: %v, but got: %v", ErrClosed, err)
+	}
+	if _, err := conn.NextReader(); !errors.Is(err, ErrClosed) {
+		t.Errorf("unexpected read error. expected: %v, but got: %v", ErrClosed, err)
+	}
+}
+
+func TestAsyncWriter_TryFlush(t *testing.T) {
+	c := &conn{
+		wch:     make(chan outMessage, 1),
+		logger:  NopLogger(),
+		metrics: NopMetrics(),
+		done:    make(chan struct{}),
+	}
+	w := c.NewWriter()
+	io.WriteString(w, "2")
+	if err := TryFlush(w); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "2")
+	if err := TryFlush(w); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("unexpected error. expected: %v, but got: %v", ErrQueueFull, err)
+	}
+	if len(c.wch) != 1 {
+		t.Errorf("unexpected queue length. expected: 1, but got: %v", len(c.wch))
+	}
+}
+
+type queueMetrics struct {
+	nopMetrics
+	mu    sync.Mutex
+	depth int
+}
+
+func (m *queueMetrics) QueueDepthAdd(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depth += delta
+}
+
+func TestConn_QueueDepth(t *testing.T) {
+	m := &queueMetrics{}
+	newConn := func() *conn {
+		return &conn{
+			wch:     make(chan outMessage, 10),
+			logger:  NopLogger(),
+			metrics: m,
+			done:    make(chan struct{}),
+		}
+	}
+	a, b := newConn(), newConn()
+	for _, c := range []*conn{a, b, b} {
+		w := c.NewWriter()
+		io.WriteString(w, "2")
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.depth != 3 {
+		t.Errorf("unexpected queue depth. expected: 3, but got: %v", m.depth)
+	}
+
+	<-b.wch
+	b.reportQueueDepth()
+	if m.depth != 2 {
+		t.Errorf("unexpected queue depth after write. expected: 2, but got: %v", m.depth)
+	}
+
+	close(a.done)
+	a.reportQueueDepth()
+	if m.depth != 1 {
+		t.Errorf("unexpected queue depth after close. expected: 1, but got: %v", m.depth)
+	}
+}
+
+func TestAsyncWriter_Discard(t *testing.T) {
+	c := &conn{
+		wch:     make(chan outMessage, 1),
+		logger:  NopLogger(),
+		metrics: NopMetrics(),
+		done:    make(chan struct{}),
+	}
+	w := c.NewWriter()
+	io.WriteString(w, "4hello")
+	Discard(NewPrefixWriter(w, nil))
+	if w.(*asyncWriter).buf != nil {
+		t.Error("buffer is not released")
+	}
+	if len(c.wch) != 0 {
+		t.Errorf("discarded message is queued")
+	}
+}
+
+func TestNewConnContext_HandshakeTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	u, _ := GetURL(strings.TrimPrefix(srv.URL, "http://"))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ts := []struct {
+		name string
+		ctx  context.Context
+		opts []ConnOption
+	}{
+		{name: "timeout", ctx: context.Background(), opts: []ConnOption{WithHandshakeTimeout(100 * time.Millisecond)}},
+		{name: "polling timeout", ctx: context.Background(), opts: []ConnOption{WithHandshakeTimeout(100 * time.Millisecond), WithPollingHandshake}},
+		{name: "cancel", ctx: cancelled},
+	}
+	for _, tc := range ts {
+		start := time.Now()
+		conn, err := NewConnContext(tc.ctx, u.String(), tc.opts...)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%v: expected error", tc.name)
+			continue
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%v: handshake was not aborted in time: %v", tc.name, elapsed)
+		}
+	}
+}
+
+func TestConn_ReadLimit(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.WriteMessage(websocket.TextMessage, []byte("4"+strings.Repeat("x", 100)))
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := GetURL(strings.TrimPrefix(srv.URL, "http://"))
+	conn, err := NewConn(u.String(), WithReadLimit(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.NextReader(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("unexpected read error. expected: %v, but got: %v", ErrMessageTooLarge, err)
+	}
+	if _, err := conn.NextReader(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("unexpected read error after close. expected: %v, but got: %v", ErrMessageTooLarge, err)
+	}
+}
+
+func TestConn_Compression(t *testing.T) {
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	extensions := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		extensions <- r.Header.Get("Sec-Websocket-Extensions")
+		for {
+			mt, b, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(mt, b)
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := GetURL(strings.TrimPrefix(srv.URL, "http://"))
+	// WithDialer after WithCompression must not reset it.
+	conn, err := NewConn(u.String(), WithCompression(1), WithDialer(&websocket.Dialer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := <-extensions; !strings.Contains(got, "permessage-deflate") {
+		t.Errorf("permessage-deflate is not negotiated: %q", got)
+	}
+
+	w := conn.NewWriter()
+	io.WriteString(w, "4hello")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := conn.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "4hello" {
+		t.Errorf("unexpected echo. expected: 4hello, but got: %q", b)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build go1.21
+// +build go1.21
+
+package engineio
+
+import "context"
+
+// afterFunc calls f in its own goroutine when ctx is done. stop prevents the call if f has not been started.
+// No goroutine is kept while waiting.
+func afterFunc(ctx context.Context, f func()) (stop func()) {
+	cancel := context.AfterFunc(ctx, f)
+	return func() {
+		cancel()
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !go1.21
+// +build !go1.21
+
+package engineio
+
+import "context"
+
+// afterFunc calls f when ctx is done. stop prevents the call if f has not been started.
+// A goroutine waits for ctx until stop is called.
+func afterFunc(ctx context.Context, f func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			f()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,183 @@
+package engineio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/orisano/gomasio"
+)
+
+type benchConn struct {
+	frame []byte
+	r     bytes.Reader
+}
+
+func (c *benchConn) NextReader() (io.Reader, error) {
+	c.r.Reset(c.frame)
+	return &c.r, nil
+}
+
+func (c *benchConn) NewWriter() gomasio.WriteFlusher {
+	return gomasio.NopFlusher(ioutil.Discard)
+}
+
+func (c *benchConn) Close() error {
+	return nil
+}
+
+func BenchmarkSocket_ReadPacket(b *testing.B) {
+	s := &socket{conn: &benchConn{frame: []byte(`42["message","hello",1]`)}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := s.readPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriterFactory_NewWriter(b *testing.B) {
+	wf := NewWriterFactory(&benchConn{})
+	body := []byte(`2["message","hello",1]`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := wf.NewWriter()
+		w.Write(body)
+		if err := w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// startIdleServer starts a server sending the handshake and then waiting for the client to close.
+func startIdleServer(b *testing.B) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.WriteMessage(websocket.TextMessage, []byte(testHandshake))
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+type openNotifier struct {
+	HandleFunc
+	opened func()
+}
+
+func (h *openNotifier) HandleOpen(ctx context.Context, wf gomasio.WriterFactory, session *Session) context.Context {
+	h.opened()
+	return ctx
+}
+
+// measureIdle reports the memory and the goroutines held by each of conns connections opened by open.
+// The in-process server is included, see BenchmarkConnect_Idle.
+func measureIdle(b *testing.B, conns int, open func(n int) (close func())) {
+	var bytesPerConn, goroutinesPerConn float64
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		goroutines := runtime.NumGoroutine()
+
+		closeAll := open(conns)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		bytesPerConn += float64(after.HeapInuse+after.StackInuse-before.HeapInuse-before.StackInuse) / float64(conns)
+		goroutinesPerConn += float64(runtime.NumGoroutine()-goroutines) / float64(conns)
+		closeAll()
+	}
+	b.ReportMetric(bytesPerConn/float64(b.N), "B/conn")
+	b.ReportMetric(goroutinesPerConn/float64(b.N), "goroutines/conn")
+}
+
+// benchmarkIdle measures idle engine.io connections over gomasio.NewConn.
+func benchmarkIdle(b *testing.B, connOpts []gomasio.ConnOption, opts ...Option) {
+	ts := startIdleServer(b)
+	defer ts.Close()
+	u, _ := gomasio.GetURL(strings.TrimPrefix(ts.URL, "http://"))
+	measureIdle(b, 1000, func(n int) func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		var opened, done sync.WaitGroup
+		opened.Add(n)
+		done.Add(n)
+		handler := &openNotifier{HandleFunc: func(gomasio.WriterFactory, io.Reader) {}, opened: opened.Done}
+		conns := make([]gomasio.Conn, 0, n)
+		for j := 0; j < n; j++ {
+			conn, err := gomasio.NewConn(u.String(), connOpts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			conns = append(conns, conn)
+			go func() {
+				defer done.Done()
+				Connect(ctx, conn, handler, opts...)
+			}()
+		}
+		opened.Wait()
+		return func() {
+			cancel()
+			for _, conn := range conns {
+				conn.Close()
+			}
+			done.Wait()
+		}
+	})
+}
+
+// BenchmarkConnect_Idle measures idle connections over real websockets to an in-process server.
+// The server side of the connections is included, so compare with the websocket sub-benchmark,
+// which holds bare client websockets, to get the cost of gomasio.
+func BenchmarkConnect_Idle(b *testing.B) {
+	b.Run("websocket", func(b *testing.B) {
+		ts := startIdleServer(b)
+		defer ts.Close()
+		u, _ := gomasio.GetURL(strings.TrimPrefix(ts.URL, "http://"))
+		measureIdle(b, 1000, func(n int) func() {
+			conns := make([]*websocket.Conn, 0, n)
+			for j := 0; j < n; j++ {
+				ws, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ws.ReadMessage()
+				conns = append(conns, ws)
+			}
+			return func() {
+				for _, ws := range conns {
+					ws.Close()
+				}
+			}
+		})
+	})
+	b.Run("default", func(b *testing.B) {
+		benchmarkIdle(b, nil)
+	})
+	b.Run("scalable", func(b *testing.B) {
+		sched := gomasio.NewWriteScheduler(8)
+		defer sched.Close()
+		wheel := NewTimingWheel(100*time.Millisecond, 512)
+		defer wheel.Stop()
+		pool := NewDispatchPool(8, 64)
+		defer pool.Close()
+		benchmarkIdle(b, []gomasio.ConnOption{gomasio.WithWriteScheduler(sched)}, WithClock(wheel), WithDispatcher(pool))
+	})
+}
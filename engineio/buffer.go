@@ -0,0 +1,61 @@
+package engineio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/orisano/gomasio"
+)
+
+// maxPooledBufferSize keeps a few huge messages from pinning memory in the pool.
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReader(nil)
+	},
+}
+
+func getReader(r io.Reader) *bufio.Reader {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+// readPayload reads the whole body so that the handler owns it after the next message is read.
+// The returned slice is never reused. limit <= 0 means no limit.
+func readPayload(r io.Reader, limit int) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			buf.Reset()
+			bufferPool.Put(buf)
+		}
+	}()
+	if limit > 0 {
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if limit > 0 && buf.Len() > limit {
+		return nil, fmt.Errorf("%w(limit=%v)", gomasio.ErrMessageTooLarge, limit)
+	}
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
+	return b, nil
+}
@@ -1,27 +1,164 @@
 package engineio
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/orisano/gomasio"
 )
 
+var ErrPingTimeout = errors.New("ping timeout")
+
 type Handler interface {
 	HandleMessage(wf gomasio.WriterFactory, body io.Reader)
 }
 
+// ContextHandler is implemented by handlers that want a context cancelled when the connection ends.
+type ContextHandler interface {
+	HandleMessageContext(ctx context.Context, wf gomasio.WriterFactory, body io.Reader)
+}
+
+// BinaryHandler is implemented by handlers that accept binary MESSAGE packets.
+// Binary messages are dropped if the handler does not implement it.
+type BinaryHandler interface {
+	HandleBinaryMessage(wf gomasio.WriterFactory, body io.Reader)
+}
+
+// OpenHandler is implemented by handlers that act on the handshake or keep per-connection state.
+// HandleOpen is called before any message is handled, and the context it returns is passed to
+// HandleMessageContext and HandleClose of the connection.
+type OpenHandler interface {
+	HandleOpen(ctx context.Context, wf gomasio.WriterFactory, session *Session) context.Context
+}
+
+// InlineHandler is implemented by handlers that take some text messages on the read goroutine instead of
+// dispatching them, such as acks that dispatched handlers may be waiting for. HandleInline must not block.
+// It reports whether it has handled body; otherwise the message is dispatched as usual.
+type InlineHandler interface {
+	HandleInline(ctx context.Context, wf gomasio.WriterFactory, body []byte) bool
+}
+
+// CloseHandler is implemented by handlers that release per-connection state.
+// HandleClose is called once the connection has ended and its message handlers have returned.
+type CloseHandler interface {
+	HandleClose(ctx context.Context)
+}
+
 type HandleFunc func(wf gomasio.WriterFactory, body io.Reader)
 
 func (f HandleFunc) HandleMessage(wf gomasio.WriterFactory, body io.Reader) {
 	f(wf, body)
 }
 
-func Connect(ctx context.Context, conn gomasio.Conn, handler Handler) error {
+type Options struct {
+	Clock   Clock
+	Logger  gomasio.Logger
+	Metrics gomasio.Metrics
+	RTT     *RTT
+	RTTHook func(latest, smoothed time.Duration)
+
+	MaxPayload     int
+	MaxMessageSize int
+
+	Dispatcher Dispatcher
+
+	DecoderOptions []DecoderOption
+
+	// ProtocolVersion is the engine.io protocol version. If it is 0, the version of the connection
+	// (see gomasio.ProtocolVersion) is used, and 3 if the connection does not know it.
+	ProtocolVersion int
+}
+
+type Option func(o *Options)
+
+func WithClock(c Clock) Option {
+	return func(o *Options) {
+		o.Clock = c
+	}
+}
+
+func WithLogger(l gomasio.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+func WithMetrics(m gomasio.Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = m
+	}
+}
+
+// WithRTT makes the connection record the heartbeat round-trip time into r.
+func WithRTT(r *RTT) Option {
+	return func(o *Options) {
+		o.RTT = r
+	}
+}
+
+func WithRTTHook(f func(latest, smoothed time.Duration)) Option {
+	return func(o *Options) {
+		o.RTTHook = f
+	}
+}
+
+// WithMaxPayload limits the size of outgoing packets. It overrides maxPayload of the handshake.
+func WithMaxPayload(n int) Option {
+	return func(o *Options) {
+		o.MaxPayload = n
+	}
+}
+
+// WithMaxMessageSize limits the size of incoming messages. A larger message closes the connection with gomasio.ErrMessageTooLarge.
+func WithMaxMessageSize(n int) Option {
+	return func(o *Options) {
+		o.MaxMessageSize = n
+	}
+}
+
+// WithDecoderOptions configures the decoder of incoming packets, e.g. StrictDecoding.
+func WithDecoderOptions(opts ...DecoderOption) Option {
+	return func(o *Options) {
+		o.DecoderOptions = append(o.DecoderOptions, opts...)
+	}
+}
+
+// WithProtocolVersion sets the engine.io protocol version of the connection.
+// With version 4 the server sends heartbeats and binary messages have no packet type.
+func WithProtocolVersion(v int) Option {
+	return func(o *Options) {
+		o.ProtocolVersion = v
+	}
+}
+
+// WithDispatcher runs message handlers by d instead of a goroutine per message.
+func WithDispatcher(d Dispatcher) Option {
+	return func(o *Options) {
+		o.Dispatcher = d
+	}
+}
+
+func Connect(ctx context.Context, conn gomasio.Conn, handler Handler, opts ...Option) error {
+	options := &Options{
+		Clock:      realClock{},
+		Logger:     gomasio.NopLogger(),
+		Metrics:    gomasio.NopMetrics(),
+		Dispatcher: goDispatcher{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.RTT == nil {
+		options.RTT = &RTT{}
+	}
+
 	r, err := conn.NextReader()
 	if err != nil {
 		return fmt.Errorf("new reader: %w", err)
@@ -30,14 +167,42 @@ func Connect(ctx context.Context, conn gomasio.Conn, handler Handler) error {
 	if err != nil {
 		return fmt.Errorf("read handshake data: %w", err)
 	}
+	if options.MaxPayload > 0 {
+		session.MaxPayload = options.MaxPayload
+	}
+	session.Version = options.ProtocolVersion
+	if session.Version == 0 {
+		session.Version = gomasio.ProtocolVersion(conn)
+	}
+	if session.Version == 0 {
+		session.Version = 3
+	}
+	options.Logger.Info("engine.io open", "sid", session.ID, "version", session.Version, "pingInterval", session.PingInterval, "pingTimeout", session.PingTimeout, "maxPayload", session.MaxPayload)
 	s := &socket{
 		conn:         conn,
+		session:      session,
+		sid:          session.ID,
+		logger:       options.Logger,
+		metrics:      options.Metrics,
+		rtt:          options.RTT,
+		rttHook:      options.RTTHook,
+		maxPayload:   session.MaxPayload,
+		maxMessage:   options.MaxMessageSize,
+		dispatcher:   options.Dispatcher,
+		decoderOpts:  options.DecoderOptions,
+		clock:        options.Clock,
 		pingInterval: time.Duration(session.PingInterval) * time.Millisecond,
 		pingTimeout:  time.Duration(session.PingTimeout) * time.Millisecond,
-		timeout:      make(chan struct{}),
 	}
+	s.heartbeat = newHeartbeat(s.clock, s.sendPing, s.expire)
 	defer s.Close()
-	return listen(ctx, s, handler)
+	err = listen(ctx, s, handler)
+	if err != nil {
+		s.logger.Error("engine.io closed", "sid", s.sid, "error", err)
+	} else {
+		s.logger.Info("engine.io closed", "sid", s.sid)
+	}
+	return err
 }
 
 func readHandshake(r io.Reader) (*Session, error) {
@@ -57,121 +222,240 @@ func readHandshake(r io.Reader) (*Session, error) {
 }
 
 func listen(ctx context.Context, s *socket, handler Handler) error {
+	wf := &writerFactory{wf: s.conn, metrics: s.metrics, maxPayload: s.maxPayload, version: s.session.Version}
+
+	handlerCtx, cancel := context.WithCancel(ctx)
+	if oh, ok := handler.(OpenHandler); ok {
+		handlerCtx = oh.HandleOpen(handlerCtx, wf, s.session)
+	}
 	var wg sync.WaitGroup
-	defer wg.Wait()
+	defer func() {
+		cancel()
+		wg.Wait()
+		if ch, ok := handler.(CloseHandler); ok {
+			ch.HandleClose(handlerCtx)
+		}
+	}()
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	// The read is interrupted by the heartbeat on ping timeout and by ctx on cancellation,
+	// so that an idle connection keeps no goroutine other than the caller of Connect.
+	if ctx.Done() != nil {
+		stop := afterFunc(ctx, s.interrupt)
+		defer stop()
+	}
 
-	wf := NewWriterFactory(s.conn)
-	s.PingAfter()
+	// With protocol 4 the server pings and the client answers, otherwise the other way around.
+	if s.session.Version >= 4 {
+		s.Heartbeat()
+	} else {
+		s.PingAfter()
+	}
 	for {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
+			s.logger.Debug("engine.io context done", "sid", s.sid, "reason", ctx.Err())
 			return nil
-		case <-s.timeout:
-			return fmt.Errorf("timeout ping response")
-		default:
-			r, err := s.conn.NextReader()
-			if err != nil {
-				return fmt.Errorf("get reader: %w", err)
-			}
-			p, err := NewDecoder(r).Decode()
-			if err != nil {
-				return fmt.Errorf("decode engine.io packet: %w", err)
-			}
+		}
+		p, payload, err := s.readPacket()
+		if atomic.LoadInt32(&s.timedOut) != 0 {
+			s.logger.Warn("engine.io ping timeout", "sid", s.sid)
+			return ErrPingTimeout
+		}
+		if ctx.Err() != nil {
+			s.logger.Debug("engine.io context done", "sid", s.sid, "reason", ctx.Err())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-			s.Heartbeat()
-			switch p.Type {
-			case OPEN:
-				return fmt.Errorf("unexpected OPEN")
-			case CLOSE:
-				return nil
-			case PING:
+		s.metrics.PacketReceived("engine.io", p.Type.String())
+		s.Heartbeat()
+		switch p.Type {
+		case OPEN:
+			return fmt.Errorf("unexpected OPEN")
+		case CLOSE:
+			s.logger.Info("engine.io close received", "sid", s.sid)
+			return nil
+		case PING:
+			if s.session.Version < 4 {
 				return fmt.Errorf("unexpected PING")
-			case PONG:
-				s.PingAfter()
-				break
-			case MESSAGE:
+			}
+			s.logger.Debug("engine.io ping received", "sid", s.sid)
+			if err := s.sendPong(p.Body); err != nil {
+				return err
+			}
+		case PONG:
+			if s.session.Version >= 4 {
+				return fmt.Errorf("unexpected PONG")
+			}
+			s.logger.Debug("engine.io pong received", "sid", s.sid)
+			s.Pong()
+			s.PingAfter()
+			break
+		case MESSAGE:
+			body := p.Body
+			if p.Binary {
+				bh, ok := handler.(BinaryHandler)
+				if !ok {
+					s.logger.Warn("engine.io binary message dropped", "sid", s.sid)
+					break
+				}
 				wg.Add(1)
-				go func() {
+				s.dispatcher.Dispatch(func() {
 					defer wg.Done()
-					handler.HandleMessage(wf, p.Body)
-				}()
-			case UPGRADE:
-				return fmt.Errorf("unsupported packet type(type=UPGRADE)")
-			case NOOP:
+					bh.HandleBinaryMessage(wf, body)
+				})
 				break
 			}
+			if ih, ok := handler.(InlineHandler); ok && ih.HandleInline(handlerCtx, wf, payload) {
+				break
+			}
+			wg.Add(1)
+			s.dispatcher.Dispatch(func() {
+				defer wg.Done()
+				if ch, ok := handler.(ContextHandler); ok {
+					ch.HandleMessageContext(handlerCtx, wf, body)
+				} else {
+					handler.HandleMessage(wf, body)
+				}
+			})
+		case UPGRADE:
+			return fmt.Errorf("unsupported packet type(type=UPGRADE)")
+		case NOOP:
+			break
+		}
+	}
+}
+
+// readPacket reads a packet. The body of MESSAGE and PING is buffered and also returned as payload.
+func (s *socket) readPacket() (p *Packet, payload []byte, err error) {
+	mt, r, err := gomasio.NextMessage(s.conn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get reader: %w", err)
+	}
+	br := getReader(r)
+	defer putReader(br)
+	r = br
+	if mt == gomasio.BinaryMessage && s.session.Version >= 4 {
+		// A binary frame of protocol 4 is a MESSAGE without the packet type.
+		p = &Packet{Type: MESSAGE, Binary: true, Body: r}
+	} else if mt == gomasio.BinaryMessage {
+		p, err = NewDecoder(r, s.decoderOpts...).DecodeBinary()
+	} else {
+		p, err = NewDecoder(r, s.decoderOpts...).Decode()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode engine.io packet: %w", err)
+	}
+	// The body must outlive the pooled reader. PING carries the probe echoed by PONG.
+	if p.Type == MESSAGE || p.Type == PING {
+		payload, err = readPayload(p.Body, s.maxMessage)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read message: %w", err)
+		}
+		p.Body = bytes.NewReader(payload)
+	}
+	return p, payload, nil
+}
+
+// interrupt unblocks a pending read by expiring the read deadline, or by closing the connection
+// if it does not support deadlines.
+func (s *socket) interrupt() {
+	if d, ok := s.conn.(interface{ SetReadDeadline(t time.Time) error }); ok {
+		if err := d.SetReadDeadline(time.Now()); err == nil {
+			return
 		}
 	}
+	if err := s.conn.Close(); err != nil {
+		s.logger.Error("engine.io close connection", "sid", s.sid, "error", err)
+	}
 }
 
 type socket struct {
 	conn         gomasio.Conn
+	session      *Session
+	sid          string
+	logger       gomasio.Logger
+	metrics      gomasio.Metrics
+	rtt          *RTT
+	rttHook      func(latest, smoothed time.Duration)
+	maxPayload   int
+	maxMessage   int
+	dispatcher   Dispatcher
+	decoderOpts  []DecoderOption
+	clock        Clock
 	pingInterval time.Duration
 	pingTimeout  time.Duration
 
-	timeout chan struct{}
-
-	pingCancel context.CancelFunc
+	timedOut  int32
+	heartbeat *heartbeat
 
-	timeoutLock   sync.Mutex
-	timeoutCancel context.CancelFunc
+	pingLock   sync.Mutex
+	pingSentAt time.Time
 }
 
 func (s *socket) PingAfter() {
-	if s.pingCancel != nil {
-		s.pingCancel()
-	}
-	ctx := context.Background()
-	ctx, s.pingCancel = context.WithCancel(ctx)
-
-	t := time.NewTimer(s.pingInterval)
-	go func() {
-		defer t.Stop()
-		select {
-		case <-t.C:
-			wf := s.conn.NewWriter()
-			WritePing(wf)
-			wf.Flush()
-			s.setTimeout(s.pingTimeout)
-		case <-ctx.Done():
-		}
-	}()
+	s.heartbeat.schedulePing(s.pingInterval)
 }
 
-func (s *socket) Heartbeat() {
-	s.setTimeout(s.pingInterval + s.pingTimeout)
+func (s *socket) sendPing() {
+	sentAt := s.clock.Now()
+	wf := s.conn.NewWriter()
+	WritePing(wf)
+	// sendPing runs on the clock, which may be a TimingWheel shared by many connections, so it must not
+	// wait for a full write queue. A dropped ping is left to time out like an unanswered one.
+	if err := gomasio.TryFlush(wf); err != nil {
+		s.logger.Error("engine.io write ping", "sid", s.sid, "error", err)
+	} else {
+		s.logger.Debug("engine.io ping sent", "sid", s.sid)
+		s.metrics.PacketSent("engine.io", PING.String())
+		s.pingLock.Lock()
+		s.pingSentAt = sentAt
+		s.pingLock.Unlock()
+	}
+	s.heartbeat.setDeadline(s.pingTimeout)
 }
 
-func (s *socket) setTimeout(d time.Duration) {
-	s.timeoutLock.Lock()
-	defer s.timeoutLock.Unlock()
-	if s.timeoutCancel != nil {
-		s.timeoutCancel()
+func (s *socket) sendPong(body io.Reader) error {
+	w := s.conn.NewWriter()
+	if _, err := w.Write(typePrefix(PONG)); err != nil {
+		return fmt.Errorf("write pong: %w", err)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		return fmt.Errorf("write pong: %w", err)
 	}
-	ctx := context.Background()
-	ctx, s.timeoutCancel = context.WithCancel(ctx)
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write pong: %w", err)
+	}
+	s.metrics.PacketSent("engine.io", PONG.String())
+	return nil
+}
 
-	t := time.NewTimer(d)
-	go func() {
-		defer t.Stop()
-		select {
-		case <-t.C:
-			s.timeout <- struct{}{}
-		case <-ctx.Done():
-		}
-	}()
+// expire interrupts the pending read, which makes listen return ErrPingTimeout.
+func (s *socket) expire() {
+	atomic.StoreInt32(&s.timedOut, 1)
+	s.interrupt()
 }
 
-func (s *socket) Close() {
-	if s.pingCancel != nil {
-		s.pingCancel()
+func (s *socket) Pong() {
+	s.pingLock.Lock()
+	sentAt := s.pingSentAt
+	s.pingSentAt = time.Time{}
+	s.pingLock.Unlock()
+	if sentAt.IsZero() {
+		return
 	}
-	s.timeoutLock.Lock()
-	defer s.timeoutLock.Unlock()
-	if s.timeoutCancel != nil {
-		s.timeoutCancel()
+	latest, smoothed := s.rtt.observe(s.clock.Now().Sub(sentAt))
+	s.metrics.HeartbeatRTT(latest)
+	if s.rttHook != nil {
+		s.rttHook(latest, smoothed)
 	}
 }
+
+func (s *socket) Heartbeat() {
+	s.heartbeat.setDeadline(s.pingInterval + s.pingTimeout)
+}
+
+func (s *socket) Close() {
+	s.heartbeat.stop()
+}
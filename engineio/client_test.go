@@ -0,0 +1,386 @@
+package engineio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/orisano/gomasio"
+)
+
+type testConn struct {
+	reading chan struct{}
+	frames  chan string
+	written chan string
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newTestConn() *testConn {
+	return &testConn{
+		reading: make(chan struct{}),
+		frames:  make(chan string),
+		written: make(chan string, 10),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *testConn) NextReader() (io.Reader, error) {
+	select {
+	case c.reading <- struct{}{}:
+	case <-c.closed:
+		return nil, io.EOF
+	}
+	select {
+	case s := <-c.frames:
+		return strings.NewReader(s), nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *testConn) NewWriter() gomasio.WriteFlusher {
+	return &testWriter{conn: c}
+}
+
+func (c *testConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// send waits for the reader to be requested and then delivers the frame.
+func (c *testConn) send(frame string) {
+	<-c.reading
+	c.frames <- frame
+}
+
+type testWriter struct {
+	conn *testConn
+	buf  bytes.Buffer
+}
+
+func (w *testWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *testWriter) Discard() {
+	w.buf.Reset()
+}
+
+func (w *testWriter) Flush() error {
+	w.conn.written <- w.buf.String()
+	return nil
+}
+
+const testHandshake = `0{"sid":"abc","pingInterval":25000,"pingTimeout":5000}`
+
+func startTestClient(t *testing.T, opts ...Option) (*testConn, *FakeClock, <-chan error) {
+	t.Helper()
+	conn := newTestConn()
+	clock := NewFakeClock(time.Unix(0, 0))
+	errc := make(chan error, 1)
+	go func() {
+		opts = append(opts, WithClock(clock))
+		errc <- Connect(context.Background(), conn, HandleFunc(func(gomasio.WriterFactory, io.Reader) {}), opts...)
+	}()
+	conn.send(testHandshake)
+	<-conn.reading
+	return conn, clock, errc
+}
+
+func expectWritten(t *testing.T, conn *testConn, expected string) {
+	t.Helper()
+	select {
+	case got := <-conn.written:
+		if got != expected {
+			t.Errorf("unexpected written frame. expected: %v, but got: %v", expected, got)
+		}
+	default:
+		t.Errorf("frame not written. expected: %v", expected)
+	}
+}
+
+func expectNotWritten(t *testing.T, conn *testConn) {
+	t.Helper()
+	select {
+	case got := <-conn.written:
+		t.Errorf("unexpected written frame: %v", got)
+	default:
+	}
+}
+
+func TestConnect_Ping(t *testing.T) {
+	conn, clock, errc := startTestClient(t)
+
+	clock.Advance(24 * time.Second)
+	expectNotWritten(t, conn)
+	clock.Advance(1 * time.Second)
+	expectWritten(t, conn, "2")
+
+	conn.frames <- "3"
+	<-conn.reading
+	clock.Advance(25 * time.Second)
+	expectWritten(t, conn, "2")
+
+	conn.frames <- "1"
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnect_PingTimeout(t *testing.T) {
+	conn, clock, errc := startTestClient(t)
+
+	clock.Advance(25 * time.Second)
+	expectWritten(t, conn, "2")
+	clock.Advance(5 * time.Second)
+
+	if err := <-errc; !errors.Is(err, ErrPingTimeout) {
+		t.Fatalf("unexpected error. expected: %v, but got: %v", ErrPingTimeout, err)
+	}
+}
+
+func TestConnect_ProtocolVersion4(t *testing.T) {
+	conn, clock, errc := startTestClient(t, WithProtocolVersion(4))
+
+	clock.Advance(25 * time.Second)
+	expectNotWritten(t, conn)
+
+	conn.frames <- "2"
+	<-conn.reading
+	expectWritten(t, conn, "3")
+	conn.frames <- "2probe"
+	<-conn.reading
+	expectWritten(t, conn, "3probe")
+
+	clock.Advance(29 * time.Second)
+	expectNotWritten(t, conn)
+	clock.Advance(1 * time.Second)
+	if err := <-errc; !errors.Is(err, ErrPingTimeout) {
+		t.Fatalf("unexpected error. expected: %v, but got: %v", ErrPingTimeout, err)
+	}
+}
+
+type lifecycleHandler struct {
+	HandleFunc
+	closed chan interface{}
+}
+
+type lifecycleKey struct{}
+
+func (h *lifecycleHandler) HandleOpen(ctx context.Context, wf gomasio.WriterFactory, session *Session) context.Context {
+	return context.WithValue(ctx, lifecycleKey{}, session.ID)
+}
+
+func (h *lifecycleHandler) HandleClose(ctx context.Context) {
+	h.closed <- ctx.Value(lifecycleKey{})
+}
+
+func TestConnect_OpenClose(t *testing.T) {
+	conn := newTestConn()
+	h := &lifecycleHandler{HandleFunc: func(gomasio.WriterFactory, io.Reader) {}, closed: make(chan interface{}, 1)}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Connect(context.Background(), conn, h, WithClock(NewFakeClock(time.Unix(0, 0))))
+	}()
+	conn.send(testHandshake)
+	select {
+	case <-h.closed:
+		t.Fatal("closed before the connection ends")
+	default:
+	}
+	conn.send("1")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := <-h.closed; got != "abc" {
+		t.Errorf("unexpected context of HandleClose. expected: abc, but got: %v", got)
+	}
+}
+
+func TestConnect_Cancel(t *testing.T) {
+	conn := newTestConn()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Connect(ctx, conn, HandleFunc(func(gomasio.WriterFactory, io.Reader) {}), WithClock(NewFakeClock(time.Unix(0, 0))))
+	}()
+	conn.send(testHandshake)
+	<-conn.reading
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnect_Heartbeat(t *testing.T) {
+	conn, clock, errc := startTestClient(t)
+
+	clock.Advance(25 * time.Second)
+	expectWritten(t, conn, "2")
+	clock.Advance(4 * time.Second)
+
+	conn.frames <- "6"
+	<-conn.reading
+	clock.Advance(29 * time.Second)
+
+	conn.frames <- "1"
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnect_RTT(t *testing.T) {
+	var rtt RTT
+	conn, clock, errc := startTestClient(t, WithRTT(&rtt))
+
+	for _, d := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		clock.Advance(25 * time.Second)
+		expectWritten(t, conn, "2")
+		clock.Advance(d)
+		conn.frames <- "3"
+		<-conn.reading
+	}
+	if got := rtt.Latest(); got != 200*time.Millisecond {
+		t.Errorf("unexpected latest rtt. expected: 200ms, but got: %v", got)
+	}
+	if got := rtt.Smoothed(); got != 112500*time.Microsecond {
+		t.Errorf("unexpected smoothed rtt. expected: 112.5ms, but got: %v", got)
+	}
+
+	conn.frames <- "1"
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnect_MaxPayload(t *testing.T) {
+	conn := newTestConn()
+	errc := make(chan error, 1)
+	flushed := make(chan error, 3)
+	handler := HandleFunc(func(wf gomasio.WriterFactory, body io.Reader) {
+		w := wf.NewWriter()
+		io.WriteString(w, "small")
+		flushed <- w.Flush()
+		w = wf.NewWriter()
+		for _, s := range []string{strings.Repeat("x", 16), "again"} {
+			io.WriteString(w, s)
+			flushed <- w.Flush()
+		}
+	})
+	go func() {
+		errc <- Connect(context.Background(), conn, handler, WithClock(NewFakeClock(time.Unix(0, 0))))
+	}()
+	conn.send(`0{"sid":"abc","pingInterval":25000,"pingTimeout":5000,"maxPayload":10}`)
+	conn.send("4hello")
+	if err := <-flushed; err != nil {
+		t.Errorf("unexpected flush error: %v", err)
+	}
+	if err := <-flushed; !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("unexpected flush error. expected: %v, but got: %v", ErrPayloadTooLarge, err)
+	}
+	// The rejected payload is discarded and the writer can be reused.
+	if err := <-flushed; err != nil {
+		t.Errorf("unexpected flush error: %v", err)
+	}
+	expectWritten(t, conn, "4small")
+	expectWritten(t, conn, "4again")
+	expectNotWritten(t, conn)
+
+	conn.send("1")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type binaryHandler chan string
+
+func (h binaryHandler) HandleMessage(wf gomasio.WriterFactory, body io.Reader) {}
+
+func (h binaryHandler) HandleBinaryMessage(wf gomasio.WriterFactory, body io.Reader) {
+	b, _ := ioutil.ReadAll(body)
+	h <- string(b)
+	w, _ := gomasio.NewMessageWriter(wf, gomasio.BinaryMessage)
+	io.WriteString(w, "world")
+	w.Flush()
+}
+
+func TestConnect_Base64Binary(t *testing.T) {
+	conn := newTestConn()
+	errc := make(chan error, 1)
+	h := make(binaryHandler, 1)
+	go func() {
+		errc <- Connect(context.Background(), conn, h, WithClock(NewFakeClock(time.Unix(0, 0))))
+	}()
+	conn.send(testHandshake)
+	conn.send("b4aGVsbG8=")
+	if got := <-h; got != "hello" {
+		t.Errorf("unexpected binary message. expected: hello, but got: %v", got)
+	}
+	conn.send("1")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	expectWritten(t, conn, "b4d29ybGQ=")
+}
+
+func TestConnect_MaxMessageSize(t *testing.T) {
+	conn := newTestConn()
+	errc := make(chan error, 1)
+	bodies := make(chan io.Reader, 2)
+	handler := HandleFunc(func(wf gomasio.WriterFactory, body io.Reader) {
+		bodies <- body
+	})
+	go func() {
+		errc <- Connect(context.Background(), conn, handler, WithClock(NewFakeClock(time.Unix(0, 0))), WithMaxMessageSize(5))
+	}()
+	conn.send(testHandshake)
+	conn.send("4hello")
+	conn.send("4world")
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		b, _ := ioutil.ReadAll(<-bodies)
+		got[string(b)] = true
+	}
+	if !got["hello"] || !got["world"] {
+		t.Errorf("unexpected bodies. expected: hello and world, but got: %v", got)
+	}
+	conn.send("4toolarge")
+	if err := <-errc; !errors.Is(err, gomasio.ErrMessageTooLarge) {
+		t.Errorf("unexpected error. expected: %v, but got: %v", gomasio.ErrMessageTooLarge, err)
+	}
+}
+
+func TestConnect_Dispatcher(t *testing.T) {
+	pool := NewDispatchPool(1, 1)
+	defer pool.Close()
+	conn := newTestConn()
+	errc := make(chan error, 1)
+	bodies := make(chan string, 1)
+	handler := HandleFunc(func(wf gomasio.WriterFactory, body io.Reader) {
+		b, _ := ioutil.ReadAll(body)
+		bodies <- string(b)
+	})
+	go func() {
+		errc <- Connect(context.Background(), conn, handler, WithClock(NewFakeClock(time.Unix(0, 0))), WithDispatcher(pool))
+	}()
+	conn.send(testHandshake)
+	conn.send("4hello")
+	if got := <-bodies; got != "hello" {
+		t.Errorf("unexpected body. expected: hello, but got: %v", got)
+	}
+	conn.send("1")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,32 +2,136 @@ package engineio
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 )
 
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+var ErrMalformedPacket = errors.New("malformed packet")
+
+type DecoderOptions struct {
+	Strict bool
+}
+
+type DecoderOption func(o *DecoderOptions)
+
+// StrictDecoding rejects trailing data after packets without a body and binary packets other than MESSAGE.
+func StrictDecoding(o *DecoderOptions) {
+	o.Strict = true
+}
+
 type Decoder struct {
-	r *bufio.Reader
+	r      byteReader
+	strict bool
 }
 
-func NewDecoder(r io.Reader) *Decoder {
+// NewDecoder returns a decoder reading from r. r is buffered unless it implements io.ByteReader.
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	var options DecoderOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
 	return &Decoder{
-		r: bufio.NewReader(r),
+		r:      br,
+		strict: options.Strict,
 	}
 }
 
+// Decode decodes a packet of a text frame. A base64 encoded binary packet ("b4...") is decoded into a binary packet.
 func (d *Decoder) Decode() (*Packet, error) {
 	b, err := d.r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
+	binary := b == 'b'
+	if binary {
+		b, err = d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+	}
 	x := b - '0'
 	if x < 0 || 6 < x {
 		return nil, fmt.Errorf("invalid packet type(type=%v)", b)
 	}
 	p := &Packet{
-		Type: PacketType(x),
+		Type:   PacketType(x),
+		Binary: binary,
+	}
+	if err := d.validate(p); err != nil {
+		return nil, err
+	}
+	p.Body = d.r
+	if binary {
+		p.Body = base64.NewDecoder(base64.StdEncoding, d.r)
+	}
+	return p, nil
+}
+
+// DecodeBinary decodes a packet of a binary frame, whose first byte is the packet type.
+func (d *Decoder) DecodeBinary() (*Packet, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if 6 < b {
+		return nil, fmt.Errorf("invalid packet type(type=%v)", b)
+	}
+	p := &Packet{
+		Type:   PacketType(b),
+		Binary: true,
+	}
+	if err := d.validate(p); err != nil {
+		return nil, err
 	}
 	p.Body = d.r
 	return p, nil
 }
+
+// maxProbeSize is the size of the longest body of a packet other than OPEN and MESSAGE ("probe").
+const maxProbeSize = len("probe")
+
+// validate checks the rest of a packet without a body in strict mode.
+func (d *Decoder) validate(p *Packet) error {
+	if !d.strict {
+		return nil
+	}
+	if p.Binary && p.Type != MESSAGE {
+		return fmt.Errorf("%w: binary %v packet", ErrMalformedPacket, p.Type)
+	}
+	switch p.Type {
+	case OPEN, MESSAGE:
+		return nil
+	}
+	var body []byte
+	for len(body) <= maxProbeSize {
+		b, err := d.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		body = append(body, b)
+	}
+	switch {
+	case len(body) == 0:
+	case (p.Type == PING || p.Type == PONG) && string(body) == "probe":
+	default:
+		return fmt.Errorf("%w: trailing data after %v packet", ErrMalformedPacket, p.Type)
+	}
+	// The body has been consumed; give it back for the caller.
+	d.r = bytes.NewReader(body)
+	return nil
+}
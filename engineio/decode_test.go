@@ -0,0 +1,47 @@
+package engineio
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecoder_Strict(t *testing.T) {
+	ts := []struct {
+		in    string
+		valid bool
+	}{
+		{in: "4hello", valid: true},
+		{in: "2probe", valid: true},
+		{in: "3", valid: true},
+		{in: "b4aGVsbG8=", valid: true},
+		{in: "1garbage", valid: false},
+		{in: "6x", valid: false},
+		{in: "3probes", valid: false},
+		{in: "b2cHJvYmU=", valid: false},
+	}
+	for _, tc := range ts {
+		p, err := NewDecoder(strings.NewReader(tc.in), StrictDecoding).Decode()
+		if tc.valid {
+			if err != nil {
+				t.Errorf("decode %q: %v", tc.in, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrMalformedPacket) {
+			t.Errorf("unexpected error of %q. expected: %v, but got: %v", tc.in, ErrMalformedPacket, err)
+		}
+		if p != nil {
+			t.Errorf("unexpected packet of %q", tc.in)
+		}
+	}
+
+	p, err := NewDecoder(strings.NewReader("2probe"), StrictDecoding).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(p.Body); string(b) != "probe" {
+		t.Errorf("unexpected body. expected: probe, but got: %s", b)
+	}
+}
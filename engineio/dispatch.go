@@ -0,0 +1,60 @@
+package engineio
+
+import "sync"
+
+// Dispatcher runs the message handlers of connections.
+type Dispatcher interface {
+	Dispatch(f func())
+}
+
+// DispatchPool is a Dispatcher running handlers on a fixed number of goroutines.
+// Dispatch blocks while all workers are busy and the queue is full, which stops reading
+// from the connection until a worker is available. Handlers must therefore not wait for a later
+// message of their connection unless it is taken by InlineHandler, as socket.io acks are;
+// otherwise the pool can deadlock.
+type DispatchPool struct {
+	queue chan func()
+	wg    sync.WaitGroup
+
+	closeOnce sync.Once
+}
+
+func NewDispatchPool(workers, queueSize int) *DispatchPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	p := &DispatchPool{
+		queue: make(chan func(), queueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *DispatchPool) Dispatch(f func()) {
+	p.queue <- f
+}
+
+// Close stops the workers after the queued handlers return.
+// Connections using p must be closed before Close.
+func (p *DispatchPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.queue)
+	})
+	p.wg.Wait()
+}
+
+func (p *DispatchPool) work() {
+	defer p.wg.Done()
+	for f := range p.queue {
+		f()
+	}
+}
+
+type goDispatcher struct{}
+
+func (goDispatcher) Dispatch(f func()) {
+	go f()
+}
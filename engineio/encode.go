@@ -1,18 +1,20 @@
 package engineio
 
 import (
-	"bufio"
+	"encoding/base64"
 	"fmt"
 	"io"
 )
 
 type Encoder struct {
-	w *bufio.Writer
+	w io.Writer
 }
 
+// NewEncoder returns an encoder writing to w. Each packet is written by a few Write calls,
+// so w is expected to be a buffered writer such as gomasio.WriteFlusher.
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
-		w: bufio.NewWriter(w),
+		w: w,
 	}
 }
 
@@ -20,16 +22,64 @@ func (e *Encoder) Encode(packet *Packet) error {
 	if packet == nil {
 		return fmt.Errorf("missing packet")
 	}
-	e.w.WriteByte(byte(int(packet.Type) + '0'))
-	if packet.Body != nil {
-		io.Copy(e.w, packet.Body)
+	if packet.Binary {
+		if _, err := e.w.Write(binaryMarker); err != nil {
+			return err
+		}
 	}
-	return e.w.Flush()
+	if _, err := e.w.Write(typePrefix(packet.Type)); err != nil {
+		return err
+	}
+	if packet.Body == nil {
+		return nil
+	}
+	if !packet.Binary {
+		_, err := io.Copy(e.w, packet.Body)
+		return err
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, e.w)
+	if _, err := io.Copy(enc, packet.Body); err != nil {
+		return err
+	}
+	return enc.Close()
 }
 
-var ping = []byte{byte(PING) + '0'}
+// EncodeBinary encodes packet for a binary frame.
+func (e *Encoder) EncodeBinary(packet *Packet) error {
+	if packet == nil {
+		return fmt.Errorf("missing packet")
+	}
+	if _, err := e.w.Write([]byte{byte(packet.Type)}); err != nil {
+		return err
+	}
+	if packet.Body == nil {
+		return nil
+	}
+	_, err := io.Copy(e.w, packet.Body)
+	return err
+}
+
+var binaryMarker = []byte{'b'}
+
+// typePrefixes holds the text encoded packet types so that writers do not allocate them.
+var typePrefixes = [...][]byte{
+	OPEN:    {byte(OPEN) + '0'},
+	CLOSE:   {byte(CLOSE) + '0'},
+	PING:    {byte(PING) + '0'},
+	PONG:    {byte(PONG) + '0'},
+	MESSAGE: {byte(MESSAGE) + '0'},
+	UPGRADE: {byte(UPGRADE) + '0'},
+	NOOP:    {byte(NOOP) + '0'},
+}
+
+func typePrefix(t PacketType) []byte {
+	if 0 <= t && int(t) < len(typePrefixes) {
+		return typePrefixes[t]
+	}
+	return []byte{byte(t) + '0'}
+}
 
 func WritePing(w io.Writer) error {
-	_, err := w.Write(ping)
+	_, err := w.Write(typePrefixes[PING])
 	return err
 }
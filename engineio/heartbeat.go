@@ -0,0 +1,111 @@
+package engineio
+
+import (
+	"sync"
+	"time"
+)
+
+// heartbeat schedules the ping and the ping timeout of a socket on a single timer.
+// Moving the deadline later, as every received packet does, only updates a field;
+// the timer is re-armed lazily when it fires before the current due time.
+type heartbeat struct {
+	clock   Clock
+	ping    func()
+	timeout func()
+
+	mu       sync.Mutex
+	pingAt   time.Time
+	deadline time.Time
+	timer    Timer
+	firesAt  time.Time
+	stopped  bool
+	// gen identifies the current timer. A timer that fires after it is replaced must not touch the state.
+	gen uint64
+}
+
+func newHeartbeat(clock Clock, ping, timeout func()) *heartbeat {
+	return &heartbeat{
+		clock:   clock,
+		ping:    ping,
+		timeout: timeout,
+	}
+}
+
+// schedulePing sends a ping after d. It replaces the previously scheduled ping.
+func (h *heartbeat) schedulePing(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pingAt = h.clock.Now().Add(d)
+	h.arm()
+}
+
+// setDeadline times out after d unless it is set again. It replaces the previous deadline.
+func (h *heartbeat) setDeadline(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deadline = h.clock.Now().Add(d)
+	h.arm()
+}
+
+func (h *heartbeat) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+}
+
+// arm makes the timer fire no later than the earliest due time. h.mu must be held.
+func (h *heartbeat) arm() {
+	if h.stopped {
+		return
+	}
+	due := h.pingAt
+	if due.IsZero() || (!h.deadline.IsZero() && h.deadline.Before(due)) {
+		due = h.deadline
+	}
+	if due.IsZero() {
+		return
+	}
+	if h.timer != nil {
+		if !h.firesAt.After(due) {
+			return
+		}
+		h.timer.Stop()
+	}
+	h.firesAt = due
+	h.gen++
+	gen := h.gen
+	h.timer = h.clock.AfterFunc(due.Sub(h.clock.Now()), func() {
+		h.fire(gen)
+	})
+}
+
+func (h *heartbeat) fire(gen uint64) {
+	h.mu.Lock()
+	if h.stopped || gen != h.gen {
+		h.mu.Unlock()
+		return
+	}
+	now := h.clock.Now()
+	h.timer = nil
+	timeout := !h.deadline.IsZero() && !now.Before(h.deadline)
+	ping := !timeout && !h.pingAt.IsZero() && !now.Before(h.pingAt)
+	if timeout {
+		h.deadline = time.Time{}
+	}
+	if ping {
+		h.pingAt = time.Time{}
+	}
+	h.arm()
+	h.mu.Unlock()
+
+	if timeout {
+		h.timeout()
+	}
+	if ping {
+		h.ping()
+	}
+}
@@ -0,0 +1,57 @@
+package engineio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var pings, timeouts int
+	h := newHeartbeat(clock, func() { pings++ }, func() { timeouts++ })
+	defer h.stop()
+
+	h.schedulePing(25 * time.Second)
+	for i := 0; i < 1000; i++ {
+		h.setDeadline(30 * time.Second)
+	}
+	if n := len(clock.timers); n != 1 {
+		t.Errorf("unexpected number of timers. expected: 1, but got: %v", n)
+	}
+
+	clock.Advance(25 * time.Second)
+	if pings != 1 || timeouts != 0 {
+		t.Errorf("unexpected callbacks at 25s. pings: %v, timeouts: %v", pings, timeouts)
+	}
+	clock.Advance(4 * time.Second)
+	h.setDeadline(30 * time.Second)
+	clock.Advance(29 * time.Second)
+	if timeouts != 0 {
+		t.Errorf("timed out before the extended deadline")
+	}
+	clock.Advance(1 * time.Second)
+	if pings != 1 || timeouts != 1 {
+		t.Errorf("unexpected callbacks at 59s. pings: %v, timeouts: %v", pings, timeouts)
+	}
+}
+
+func TestHeartbeat_StaleFire(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var pings, timeouts int
+	h := newHeartbeat(clock, func() { pings++ }, func() { timeouts++ })
+	defer h.stop()
+
+	h.schedulePing(10 * time.Second)
+	stale := clock.timers[0].f
+	h.setDeadline(5 * time.Second)
+	// The replaced timer fires while the earlier one is armed, as when it raced with Stop.
+	stale()
+	if n := len(clock.timers); n != 1 {
+		t.Errorf("unexpected number of timers. expected: 1, but got: %v", n)
+	}
+
+	clock.Advance(10 * time.Second)
+	if pings != 1 || timeouts != 1 {
+		t.Errorf("unexpected callbacks at 10s. pings: %v, timeouts: %v", pings, timeouts)
+	}
+}
@@ -1,6 +1,16 @@
 package engineio
 
-import "io"
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/orisano/gomasio/internal/peek"
+)
 
 type PacketType int
 
@@ -16,7 +26,119 @@ const (
 	INVALID PacketType = -1
 )
 
+var packetTypeNames = [...]string{"OPEN", "CLOSE", "PING", "PONG", "MESSAGE", "UPGRADE", "NOOP"}
+
+func (t PacketType) String() string {
+	if t < 0 || int(t) >= len(packetTypeNames) {
+		return "INVALID"
+	}
+	return packetTypeNames[t]
+}
+
 type Packet struct {
-	Type PacketType
-	Body io.Reader
+	Type   PacketType
+	Binary bool
+	Body   io.Reader
+}
+
+func NewOpenPacket(s *Session) (*Packet, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("marshal session: %w", err)
+	}
+	return &Packet{Type: OPEN, Body: bytes.NewReader(b)}, nil
+}
+
+func NewClosePacket() *Packet {
+	return &Packet{Type: CLOSE}
+}
+
+// NewPingPacket returns a PING packet. probe is "probe" on transport upgrade, and empty otherwise.
+func NewPingPacket(probe string) *Packet {
+	return &Packet{Type: PING, Body: strings.NewReader(probe)}
+}
+
+// NewPongPacket returns a PONG packet echoing probe of the PING packet.
+func NewPongPacket(probe string) *Packet {
+	return &Packet{Type: PONG, Body: strings.NewReader(probe)}
+}
+
+func NewMessagePacket(body []byte) *Packet {
+	return &Packet{Type: MESSAGE, Body: bytes.NewReader(body)}
+}
+
+func NewBinaryMessagePacket(body []byte) *Packet {
+	return &Packet{Type: MESSAGE, Binary: true, Body: bytes.NewReader(body)}
+}
+
+func NewUpgradePacket() *Packet {
+	return &Packet{Type: UPGRADE}
+}
+
+func NewNoopPacket() *Packet {
+	return &Packet{Type: NOOP}
+}
+
+// ParsePacket parses s encoded by Encoder.Encode, e.g. "4hello" or "b4AQID".
+// The body of the returned packet is read, so that it can be formatted and read again.
+func ParsePacket(s string) (*Packet, error) {
+	p, err := NewDecoder(strings.NewReader(s)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(p.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	p.Body = bytes.NewReader(b)
+	return p, nil
+}
+
+// String returns the packet type followed by the body, e.g. `MESSAGE 2["hello"]`.
+// A binary body is shown in base64. The body is shown only if it can be read without consuming it,
+// as the bodies of ParsePacket and the constructors can.
+func (p *Packet) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.Type.String())
+	if p.Binary {
+		sb.WriteString(" binary")
+	}
+	if p.Body == nil {
+		return sb.String()
+	}
+	b, ok := peek.Bytes(p.Body)
+	if !ok {
+		sb.WriteString(" (unread body)")
+		return sb.String()
+	}
+	if len(b) == 0 {
+		return sb.String()
+	}
+	sb.WriteByte(' ')
+	if p.Binary {
+		sb.WriteString(base64.StdEncoding.EncodeToString(b))
+	} else {
+		sb.Write(b)
+	}
+	return sb.String()
+}
+
+// Format implements fmt.Formatter. %+v prints the fields, and the other verbs print String.
+func (p *Packet) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		body := "nil"
+		if p.Body != nil {
+			if b, ok := peek.Bytes(p.Body); ok {
+				body = fmt.Sprintf("%q", b)
+			} else {
+				body = "(unread)"
+			}
+		}
+		fmt.Fprintf(f, "{Type:%v Binary:%v Body:%v}", p.Type, p.Binary, body)
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", p.String())
+	default:
+		io.WriteString(f, p.String())
+	}
 }
@@ -0,0 +1,57 @@
+package engineio
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestParsePacket(t *testing.T) {
+	open, err := NewOpenPacket(&Session{ID: "abc", PingInterval: 25000, PingTimeout: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := []struct {
+		packet   *Packet
+		encoded  string
+		expected string
+	}{
+		{packet: open, encoded: `0{"sid":"abc","pingInterval":25000,"pingTimeout":5000}`, expected: `OPEN {"sid":"abc","pingInterval":25000,"pingTimeout":5000}`},
+		{packet: NewClosePacket(), encoded: "1", expected: "CLOSE"},
+		{packet: NewPingPacket("probe"), encoded: "2probe", expected: "PING probe"},
+		{packet: NewPongPacket(""), encoded: "3", expected: "PONG"},
+		{packet: NewMessagePacket([]byte(`2["hello"]`)), encoded: `42["hello"]`, expected: `MESSAGE 2["hello"]`},
+		{packet: NewBinaryMessagePacket([]byte{1, 2, 3}), encoded: "b4AQID", expected: "MESSAGE binary AQID"},
+		{packet: NewUpgradePacket(), encoded: "5", expected: "UPGRADE"},
+		{packet: NewNoopPacket(), encoded: "6", expected: "NOOP"},
+	}
+	for _, tc := range ts {
+		if got := tc.packet.String(); got != tc.expected {
+			t.Errorf("unexpected string. expected: %v, but got: %v", tc.expected, got)
+		}
+		var b bytes.Buffer
+		if err := NewEncoder(&b).Encode(tc.packet); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tc.encoded {
+			t.Errorf("unexpected encoded packet. expected: %v, but got: %v", tc.encoded, b.String())
+		}
+		p, err := ParsePacket(b.String())
+		if err != nil {
+			t.Fatalf("parse %q: %v", b.String(), err)
+		}
+		if got := fmt.Sprint(p); got != tc.expected {
+			t.Errorf("unexpected parsed packet. expected: %v, but got: %v", tc.expected, got)
+		}
+	}
+}
+
+func TestPacket_Format(t *testing.T) {
+	p := NewMessagePacket([]byte("hello"))
+	if got, expected := fmt.Sprintf("%+v", p), `{Type:MESSAGE Binary:false Body:"hello"}`; got != expected {
+		t.Errorf("unexpected format. expected: %v, but got: %v", expected, got)
+	}
+	if got, expected := fmt.Sprintf("%v", PacketType(9)), "INVALID"; got != expected {
+		t.Errorf("unexpected packet type. expected: %v, but got: %v", expected, got)
+	}
+}
@@ -0,0 +1,46 @@
+package engineio
+
+import (
+	"sync"
+	"time"
+)
+
+// RTT holds the ping to pong round-trip time of a connection.
+type RTT struct {
+	mu       sync.Mutex
+	latest   time.Duration
+	smoothed time.Duration
+	samples  int
+}
+
+func (r *RTT) observe(d time.Duration) (latest, smoothed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latest = d
+	if r.samples == 0 {
+		r.smoothed = d
+	} else {
+		// same as the smoothed RTT of TCP (RFC 6298)
+		r.smoothed = (7*r.smoothed + d) / 8
+	}
+	r.samples++
+	return r.latest, r.smoothed
+}
+
+func (r *RTT) Latest() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latest
+}
+
+func (r *RTT) Smoothed() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.smoothed
+}
+
+func (r *RTT) Samples() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.samples
+}
@@ -4,4 +4,8 @@ type Session struct {
 	ID           string `json:"sid"`
 	PingInterval int    `json:"pingInterval"`
 	PingTimeout  int    `json:"pingTimeout"`
+	MaxPayload   int    `json:"maxPayload,omitempty"`
+
+	// Version is the negotiated engine.io protocol version.
+	Version int `json:"-"`
 }
@@ -0,0 +1,130 @@
+package engineio
+
+import (
+	"sync"
+	"time"
+)
+
+// TimingWheel is a Clock running the timers of many connections on a single goroutine.
+// Timers fire on the first tick at or after their expiration, so they are late by up to one tick.
+// Timer functions run on the wheel goroutine one by one and must not block.
+type TimingWheel struct {
+	tick  time.Duration
+	start time.Time
+
+	mu      sync.Mutex
+	slots   [][]*wheelTimer
+	current int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTimingWheel starts a wheel of slots buckets advancing every tick.
+// A timer longer than tick*slots waits for several turns of the wheel.
+func NewTimingWheel(tick time.Duration, slots int) *TimingWheel {
+	if slots <= 0 {
+		slots = 1
+	}
+	w := &TimingWheel{
+		tick:  tick,
+		start: time.Now(),
+		slots: make([][]*wheelTimer, slots),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *TimingWheel) Now() time.Time {
+	return time.Now()
+}
+
+func (w *TimingWheel) AfterFunc(d time.Duration, f func()) Timer {
+	at := time.Now().Add(d)
+	n := int64((at.Sub(w.start) + w.tick - 1) / w.tick)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n <= w.current {
+		n = w.current + 1
+	}
+	t := &wheelTimer{wheel: w, tick: n, f: f}
+	i := n % int64(len(w.slots))
+	w.slots[i] = append(w.slots[i], t)
+	return t
+}
+
+// Stop stops the wheel. Pending timers never fire.
+func (w *TimingWheel) Stop() {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.done
+}
+
+func (w *TimingWheel) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, t := range w.advance(time.Now()) {
+				t.f()
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// advance moves the wheel up to now and returns the expired timers.
+func (w *TimingWheel) advance(now time.Time) []*wheelTimer {
+	end := int64(now.Sub(w.start) / w.tick)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var expired []*wheelTimer
+	for w.current < end {
+		w.current++
+		i := w.current % int64(len(w.slots))
+		slot := w.slots[i]
+		rest := slot[:0]
+		for _, t := range slot {
+			switch {
+			case t.stopped:
+			case t.tick <= w.current:
+				t.stopped = true
+				expired = append(expired, t)
+			default:
+				rest = append(rest, t)
+			}
+		}
+		for j := len(rest); j < len(slot); j++ {
+			slot[j] = nil
+		}
+		w.slots[i] = rest
+	}
+	return expired
+}
+
+type wheelTimer struct {
+	wheel   *TimingWheel
+	tick    int64
+	f       func()
+	stopped bool
+}
+
+func (t *wheelTimer) Stop() bool {
+	t.wheel.mu.Lock()
+	defer t.wheel.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	return true
+}
@@ -0,0 +1,98 @@
+package engineio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/orisano/gomasio"
+)
+
+func TestTimingWheel(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond, 8)
+	defer w.Stop()
+
+	start := time.Now()
+	fired := make(chan time.Duration, 1)
+	w.AfterFunc(20*time.Millisecond, func() {
+		fired <- time.Since(start)
+	})
+	stopped := w.AfterFunc(10*time.Millisecond, func() {
+		t.Error("stopped timer fired")
+	})
+	if !stopped.Stop() {
+		t.Error("Stop of a pending timer returned false")
+	}
+
+	select {
+	case d := <-fired:
+		if d < 20*time.Millisecond {
+			t.Errorf("timer fired too early: %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+}
+
+// fullQueueConn is a connection whose write queue is full: Flush blocks until it is closed.
+type fullQueueConn struct {
+	*testConn
+}
+
+func (c *fullQueueConn) NewWriter() gomasio.WriteFlusher {
+	return &fullQueueWriter{conn: c.testConn}
+}
+
+type fullQueueWriter struct {
+	conn *testConn
+}
+
+func (w *fullQueueWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *fullQueueWriter) Flush() error {
+	<-w.conn.closed
+	return gomasio.ErrClosed
+}
+
+func (w *fullQueueWriter) TryFlush() error {
+	return gomasio.ErrQueueFull
+}
+
+func TestTimingWheel_FullQueue(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond, 64)
+	defer w.Stop()
+
+	const handshake = `0{"sid":"abc","pingInterval":10,"pingTimeout":10}`
+	stuck := &fullQueueConn{newTestConn()}
+	defer stuck.Close()
+	idle := newTestConn()
+	defer idle.Close()
+	errc := make(chan error, 2)
+	for _, conn := range []gomasio.Conn{stuck, idle} {
+		conn := conn
+		go func() {
+			errc <- Connect(context.Background(), conn, HandleFunc(func(gomasio.WriterFactory, io.Reader) {}), WithClock(w))
+		}()
+	}
+	stuck.send(handshake)
+	idle.send(handshake)
+	go func() {
+		for range idle.written {
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if !errors.Is(err, ErrPingTimeout) {
+				t.Errorf("unexpected error. expected: %v, but got: %v", ErrPingTimeout, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ping timeout did not fire")
+		}
+	}
+}
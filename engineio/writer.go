@@ -1,23 +1,132 @@
 package engineio
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
 	"github.com/orisano/gomasio"
 )
 
+var ErrPayloadTooLarge = errors.New("packet exceeds maxPayload")
+
 func NewWriter(wf gomasio.WriteFlusher, packetType PacketType) gomasio.WriteFlusher {
-	return gomasio.NewPrefixWriter(wf, []byte{byte(packetType) + '0'})
+	return gomasio.NewPrefixWriter(wf, typePrefix(packetType))
+}
+
+// NewBinaryWriter returns a writer of a binary packet. The packet is sent as a binary frame if wf
+// supports it, and otherwise as a base64 encoded text frame ("b4...").
+func NewBinaryWriter(wf gomasio.WriterFactory, packetType PacketType) gomasio.WriteFlusher {
+	return newBinaryWriter(wf, []byte{byte(packetType)}, []byte{'b', byte(packetType) + '0'})
+}
+
+// newBinaryMessageWriter returns a writer of a binary MESSAGE of protocol 4, which has no packet type.
+func newBinaryMessageWriter(wf gomasio.WriterFactory) gomasio.WriteFlusher {
+	return newBinaryWriter(wf, nil, []byte{'b'})
+}
+
+func newBinaryWriter(wf gomasio.WriterFactory, prefix, textPrefix []byte) gomasio.WriteFlusher {
+	if w, err := gomasio.NewMessageWriter(wf, gomasio.BinaryMessage); err == nil {
+		if len(prefix) == 0 {
+			return w
+		}
+		return gomasio.NewPrefixWriter(w, prefix)
+	}
+	w := wf.NewWriter()
+	w.Write(textPrefix)
+	return &base64Writer{
+		wf:  w,
+		enc: base64.NewEncoder(base64.StdEncoding, w),
+	}
+}
+
+type base64Writer struct {
+	wf  gomasio.WriteFlusher
+	enc io.WriteCloser
+}
+
+func (w *base64Writer) Write(p []byte) (n int, err error) {
+	return w.enc.Write(p)
+}
+
+func (w *base64Writer) Discard() {
+	gomasio.Discard(w.wf)
+}
+
+func (w *base64Writer) Flush() error {
+	if err := w.enc.Close(); err != nil {
+		return err
+	}
+	return w.wf.Flush()
 }
 
 type writerFactory struct {
-	wf gomasio.WriterFactory
+	wf         gomasio.WriterFactory
+	metrics    gomasio.Metrics
+	maxPayload int
+	version    int
 }
 
 func (w *writerFactory) NewWriter() gomasio.WriteFlusher {
-	return NewWriter(w.wf.NewWriter(), MESSAGE)
+	return &countFlusher{
+		wf:         NewWriter(w.wf.NewWriter(), MESSAGE),
+		metrics:    w.metrics,
+		maxPayload: w.maxPayload,
+	}
+}
+
+func (w *writerFactory) NewMessageWriter(mt gomasio.MessageType) (gomasio.WriteFlusher, error) {
+	var wf gomasio.WriteFlusher
+	switch mt {
+	case gomasio.TextMessage:
+		wf = NewWriter(w.wf.NewWriter(), MESSAGE)
+	case gomasio.BinaryMessage:
+		if w.version >= 4 {
+			wf = newBinaryMessageWriter(w.wf)
+		} else {
+			wf = NewBinaryWriter(w.wf, MESSAGE)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported message type: %v", mt)
+	}
+	return &countFlusher{
+		wf:         wf,
+		metrics:    w.metrics,
+		maxPayload: w.maxPayload,
+	}, nil
 }
 
 func NewWriterFactory(wf gomasio.WriterFactory) gomasio.WriterFactory {
 	return &writerFactory{
-		wf: wf,
+		wf:      wf,
+		metrics: gomasio.NopMetrics(),
+	}
+}
+
+type countFlusher struct {
+	wf         gomasio.WriteFlusher
+	metrics    gomasio.Metrics
+	maxPayload int
+	n          int
+}
+
+func (w *countFlusher) Write(p []byte) (n int, err error) {
+	n, err = w.wf.Write(p)
+	w.n += n
+	return n, err
+}
+
+func (w *countFlusher) Flush() error {
+	if w.maxPayload > 0 && w.n > w.maxPayload {
+		n := w.n
+		gomasio.Discard(w.wf)
+		w.n = 0
+		return fmt.Errorf("%w(size=%v, maxPayload=%v)", ErrPayloadTooLarge, n, w.maxPayload)
+	}
+	if err := w.wf.Flush(); err != nil {
+		return err
 	}
+	w.metrics.PacketSent("engine.io", MESSAGE.String())
+	return nil
 }
@@ -0,0 +1,89 @@
+package gomasio
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/orisano/gomasio/internal/histogram"
+)
+
+type expvarMetrics struct {
+	m  *expvar.Map
+	mu sync.Mutex
+
+	packetsIn  *expvar.Map
+	packetsOut *expvar.Map
+	bytesIn    *expvar.Int
+	bytesOut   *expvar.Int
+	queueDepth *expvar.Int
+	queueMax   *expvar.Int
+
+	ackLatency      *histogram.Histogram
+	heartbeatRTT    *histogram.Histogram
+	handlerDuration *histogram.Histogram
+}
+
+// NewExpvarMetrics publishes the metrics as an expvar.Map named name.
+// Like expvar.Publish, it panics if name is already registered.
+func NewExpvarMetrics(name string) Metrics {
+	e := &expvarMetrics{
+		m:               expvar.NewMap(name),
+		packetsIn:       new(expvar.Map).Init(),
+		packetsOut:      new(expvar.Map).Init(),
+		bytesIn:         new(expvar.Int),
+		bytesOut:        new(expvar.Int),
+		queueDepth:      new(expvar.Int),
+		queueMax:        new(expvar.Int),
+		ackLatency:      histogram.New(),
+		heartbeatRTT:    histogram.New(),
+		handlerDuration: histogram.New(),
+	}
+	e.m.Set("packets_in", e.packetsIn)
+	e.m.Set("packets_out", e.packetsOut)
+	e.m.Set("bytes_in", e.bytesIn)
+	e.m.Set("bytes_out", e.bytesOut)
+	e.m.Set("queue_depth", e.queueDepth)
+	e.m.Set("queue_depth_max", e.queueMax)
+	e.m.Set("ack_latency", e.ackLatency)
+	e.m.Set("heartbeat_rtt", e.heartbeatRTT)
+	e.m.Set("handler_duration", e.handlerDuration)
+	return e
+}
+
+func (e *expvarMetrics) PacketReceived(protocol, packetType string) {
+	e.packetsIn.Add(protocol+"."+packetType, 1)
+}
+
+func (e *expvarMetrics) PacketSent(protocol, packetType string) {
+	e.packetsOut.Add(protocol+"."+packetType, 1)
+}
+
+func (e *expvarMetrics) BytesReceived(n int) {
+	e.bytesIn.Add(int64(n))
+}
+
+func (e *expvarMetrics) BytesSent(n int) {
+	e.bytesOut.Add(int64(n))
+}
+
+func (e *expvarMetrics) QueueDepthAdd(delta int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.queueDepth.Add(int64(delta))
+	if n := e.queueDepth.Value(); n > e.queueMax.Value() {
+		e.queueMax.Set(n)
+	}
+}
+
+func (e *expvarMetrics) AckLatency(d time.Duration) {
+	e.ackLatency.Observe(d)
+}
+
+func (e *expvarMetrics) HeartbeatRTT(d time.Duration) {
+	e.heartbeatRTT.Observe(d)
+}
+
+func (e *expvarMetrics) HandlerDuration(d time.Duration) {
+	e.handlerDuration.Observe(d)
+}
@@ -0,0 +1,118 @@
+// Package histogram provides the latency histogram shared by the expvar metrics and gomasio-load.
+package histogram
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bounds are the upper bounds of the buckets from 100µs to 1m in 1-2-5 steps.
+// Durations beyond the last bound fall into the +Inf bucket.
+var Bounds = func() []time.Duration {
+	var bounds []time.Duration
+	for d := 100 * time.Microsecond; d <= time.Minute; d *= 10 {
+		bounds = append(bounds, d, 2*d, 5*d)
+	}
+	return bounds
+}()
+
+// Label returns the upper bound of the i-th bucket, e.g. "5ms" or "+Inf".
+func Label(i int) string {
+	if i < len(Bounds) {
+		return Bounds[i].String()
+	}
+	return "+Inf"
+}
+
+// Histogram counts durations in Bounds. It is safe for concurrent use, and String makes it an expvar.Var.
+type Histogram struct {
+	mu sync.Mutex
+	s  Snapshot
+}
+
+// Snapshot is the state of a Histogram. Counts[i] is the count of the i-th bucket.
+type Snapshot struct {
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+func New() *Histogram {
+	return &Histogram{s: Snapshot{Counts: make([]int64, len(Bounds)+1)}}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &h.s
+	i := sort.Search(len(Bounds), func(i int) bool { return d <= Bounds[i] })
+	s.Counts[i]++
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+	if d > s.Max {
+		s.Max = d
+	}
+	s.Count++
+	s.Sum += d
+}
+
+// Snapshot returns a copy of the state.
+func (h *Histogram) Snapshot() Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.s
+	s.Counts = append([]int64(nil), h.s.Counts...)
+	return s
+}
+
+// String returns the state as JSON with the bucket counts keyed by Label.
+func (h *Histogram) String() string {
+	s := h.Snapshot()
+	buckets := make(map[string]int64, len(s.Counts))
+	for i, c := range s.Counts {
+		buckets[Label(i)] = c
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"count":   s.Count,
+		"sum_ns":  int64(s.Sum),
+		"min_ns":  int64(s.Min),
+		"max_ns":  int64(s.Max),
+		"buckets": buckets,
+	})
+	return string(b)
+}
+
+// Mean returns the mean of the durations, or 0 if there are none.
+func (s Snapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns the upper bound of the bucket that contains the q-th observation, capped by Max.
+func (s Snapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Count))
+	if rank >= s.Count {
+		rank = s.Count - 1
+	}
+	var seen int64
+	for i, c := range s.Counts {
+		seen += c
+		if seen > rank {
+			if i == len(Bounds) || Bounds[i] > s.Max {
+				return s.Max
+			}
+			return Bounds[i]
+		}
+	}
+	return s.Max
+}
@@ -0,0 +1,42 @@
+package histogram
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := New()
+	for _, d := range []time.Duration{150 * time.Microsecond, 3 * time.Millisecond, 4 * time.Millisecond, 2 * time.Hour} {
+		h.Observe(d)
+	}
+	s := h.Snapshot()
+	if s.Count != 4 || s.Min != 150*time.Microsecond || s.Max != 2*time.Hour {
+		t.Errorf("unexpected snapshot: %+v", s)
+	}
+	ts := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{0, 200 * time.Microsecond},
+		{0.5, 5 * time.Millisecond},
+		{0.99, 2 * time.Hour},
+	}
+	for _, tc := range ts {
+		if got := s.Quantile(tc.q); got != tc.expected {
+			t.Errorf("unexpected quantile(%v). expected: %v, but got: %v", tc.q, tc.expected, got)
+		}
+	}
+
+	var v struct {
+		Count   int64            `json:"count"`
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(h.String()), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Count != 4 || v.Buckets["5ms"] != 2 || v.Buckets["+Inf"] != 1 {
+		t.Errorf("unexpected string: %v", h.String())
+	}
+}
@@ -0,0 +1,27 @@
+// Package peek reads the bodies of engine.io and socket.io packets without consuming them.
+package peek
+
+import (
+	"bytes"
+	"io"
+)
+
+// Bytes returns the unread bytes of r without consuming them if r is a bytes.Reader,
+// a strings.Reader or a bytes.Buffer.
+func Bytes(r io.Reader) ([]byte, bool) {
+	switch r := r.(type) {
+	case interface {
+		io.ReaderAt
+		Len() int
+		Size() int64
+	}:
+		b := make([]byte, r.Len())
+		if _, err := r.ReadAt(b, r.Size()-int64(r.Len())); err != nil && err != io.EOF {
+			return nil, false
+		}
+		return b, true
+	case *bytes.Buffer:
+		return r.Bytes(), true
+	}
+	return nil, false
+}
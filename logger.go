@@ -0,0 +1,55 @@
+package gomasio
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is a leveled key-value logger. *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s *stdLogger) output(level, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	s.l.Output(3, b.String())
+}
+
+func (s *stdLogger) Debug(msg string, args ...interface{}) { s.output("DEBUG", msg, args) }
+func (s *stdLogger) Info(msg string, args ...interface{})  { s.output("INFO", msg, args) }
+func (s *stdLogger) Warn(msg string, args ...interface{})  { s.output("WARN", msg, args) }
+func (s *stdLogger) Error(msg string, args ...interface{}) { s.output("ERROR", msg, args) }
+
+// StdLogger adapts a *log.Logger to Logger.
+func StdLogger(l *log.Logger) Logger {
+	return &stdLogger{l: l}
+}
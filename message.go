@@ -0,0 +1,47 @@
+package gomasio
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = websocket.TextMessage
+	BinaryMessage MessageType = websocket.BinaryMessage
+)
+
+var ErrBinaryUnsupported = errors.New("binary message is not supported")
+
+// MessageReader is implemented by a Conn that reports the frame type of incoming messages.
+type MessageReader interface {
+	NextMessage() (MessageType, io.Reader, error)
+}
+
+// MessageWriterFactory is implemented by a WriterFactory that can write binary frames.
+type MessageWriterFactory interface {
+	NewMessageWriter(mt MessageType) (WriteFlusher, error)
+}
+
+// NextMessage reads the next message of c. A Conn without MessageReader only reads text messages.
+func NextMessage(c Conn) (MessageType, io.Reader, error) {
+	if mr, ok := c.(MessageReader); ok {
+		return mr.NextMessage()
+	}
+	r, err := c.NextReader()
+	return TextMessage, r, err
+}
+
+// NewMessageWriter returns a writer of mt. It fails with ErrBinaryUnsupported if wf cannot write binary frames.
+func NewMessageWriter(wf WriterFactory, mt MessageType) (WriteFlusher, error) {
+	if mwf, ok := wf.(MessageWriterFactory); ok {
+		return mwf.NewMessageWriter(mt)
+	}
+	if mt != TextMessage {
+		return nil, ErrBinaryUnsupported
+	}
+	return wf.NewWriter(), nil
+}
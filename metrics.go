@@ -0,0 +1,32 @@
+package gomasio
+
+import "time"
+
+// Metrics receives instrumentation events from Conn, engineio and socketio.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	PacketReceived(protocol, packetType string)
+	PacketSent(protocol, packetType string)
+	BytesReceived(n int)
+	BytesSent(n int)
+	// QueueDepthAdd adds delta to the number of messages queued for writing over all connections.
+	QueueDepthAdd(delta int)
+	AckLatency(d time.Duration)
+	HeartbeatRTT(d time.Duration)
+	HandlerDuration(d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) PacketReceived(protocol, packetType string) {}
+func (nopMetrics) PacketSent(protocol, packetType string)     {}
+func (nopMetrics) BytesReceived(n int)                        {}
+func (nopMetrics) BytesSent(n int)                            {}
+func (nopMetrics) QueueDepthAdd(delta int)                    {}
+func (nopMetrics) AckLatency(d time.Duration)                 {}
+func (nopMetrics) HeartbeatRTT(d time.Duration)               {}
+func (nopMetrics) HandlerDuration(d time.Duration)            {}
+
+func NopMetrics() Metrics {
+	return nopMetrics{}
+}
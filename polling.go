@@ -0,0 +1,186 @@
+package gomasio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// pollingTransport returns a transport connecting as d does, so that the polling handshake
+// goes through the same dial functions, proxy and TLS configuration as the upgrade.
+func pollingTransport(d *websocket.Dialer) *http.Transport {
+	t := &http.Transport{
+		Proxy:               d.Proxy,
+		TLSClientConfig:     d.TLSClientConfig,
+		TLSHandshakeTimeout: d.HandshakeTimeout,
+		DialContext:         d.NetDialContext,
+		DialTLSContext:      d.NetDialTLSContext,
+	}
+	if t.DialContext == nil && d.NetDial != nil {
+		netDial := d.NetDial
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return netDial(network, addr)
+		}
+	}
+	return t
+}
+
+// pollingHandshake performs the engine.io handshake over HTTP long-polling and returns
+// the websocket URL for upgrading the session together with the packets received so far.
+func pollingHandshake(ctx context.Context, client *http.Client, wsURL string, header http.Header) (string, [][]byte, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse url: %w", err)
+	}
+	pu := *u
+	switch u.Scheme {
+	case "ws":
+		pu.Scheme = "http"
+	case "wss":
+		pu.Scheme = "https"
+	}
+	q := pu.Query()
+	q.Set("transport", "polling")
+	q.Set("b64", "1")
+	pu.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pu.String(), nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("new request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("polling handshake: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, newHandshakeError(resp, errors.New("polling handshake: unexpected status"))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("read polling response: %w", err)
+	}
+
+	// An engine.io v3 server answers with the v3 payload format even if EIO=4 is requested.
+	var packets [][]byte
+	if q.Get("EIO") == "3" || !bytes.HasPrefix(body, []byte{'0'}) {
+		packets, err = decodePayloadV3(body)
+	} else {
+		packets = bytes.Split(body, []byte{0x1e})
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("decode polling payload: %w", err)
+	}
+	if len(packets) == 0 || len(packets[0]) == 0 || packets[0][0] != '0' {
+		return "", nil, fmt.Errorf("polling handshake: missing OPEN packet: %q", body)
+	}
+	var session struct {
+		ID string `json:"sid"`
+	}
+	if err := json.Unmarshal(packets[0][1:], &session); err != nil {
+		return "", nil, fmt.Errorf("polling handshake: invalid session json: %w", err)
+	}
+	if session.ID == "" {
+		return "", nil, fmt.Errorf("polling handshake: missing sid")
+	}
+
+	wq := u.Query()
+	wq.Set("transport", "websocket")
+	wq.Set("sid", session.ID)
+	u.RawQuery = wq.Encode()
+	return u.String(), packets, nil
+}
+
+// decodePayloadV3 decodes an engine.io v3 text payload (<length>:<packet>...),
+// where length is counted in UTF-16 code units.
+func decodePayloadV3(b []byte) ([][]byte, error) {
+	var packets [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("missing length separator")
+		}
+		n, err := strconv.Atoi(string(b[:i]))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid packet length: %q", b[:i])
+		}
+		b = b[i+1:]
+		end := 0
+		for units := 0; units < n; {
+			if end >= len(b) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			r, size := utf8.DecodeRune(b[end:])
+			end += size
+			if r > 0xFFFF {
+				units += 2
+			} else {
+				units++
+			}
+		}
+		packets = append(packets, b[:end])
+		b = b[end:]
+	}
+	return packets, nil
+}
+
+// interruptRead makes the reads of ws fail when ctx is done until stop is called.
+// stop returns ctx.Err() if the reads may have been interrupted.
+func interruptRead(ctx context.Context, ws *websocket.Conn) (stop func() error) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			ws.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() error {
+		close(done)
+		<-exited
+		ws.SetReadDeadline(time.Time{})
+		return ctx.Err()
+	}
+}
+
+// upgradeProbe performs the engine.io transport upgrade on ws.
+func upgradeProbe(ctx context.Context, ws *websocket.Conn) (err error) {
+	stop := interruptRead(ctx, ws)
+	defer func() {
+		if serr := stop(); serr != nil {
+			err = fmt.Errorf("upgrade probe: %w", serr)
+		}
+	}()
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("2probe")); err != nil {
+		return fmt.Errorf("write probe: %w", err)
+	}
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("read probe: %w", err)
+	}
+	if string(b) != "3probe" {
+		return fmt.Errorf("unexpected probe response: %q", b)
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("5")); err != nil {
+		return fmt.Errorf("write upgrade: %w", err)
+	}
+	return nil
+}
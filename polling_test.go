@@ -0,0 +1,117 @@
+package gomasio
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNewConn_PollingHandshake(t *testing.T) {
+	const handshake = `0{"sid":"abc","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}`
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("transport") {
+		case "polling":
+			http.SetCookie(w, &http.Cookie{Name: "AWSALB", Value: "sticky"})
+			w.Write([]byte(strconv.Itoa(len(handshake)) + ":" + handshake + "2:40"))
+		case "websocket":
+			if q.Get("sid") != "abc" {
+				http.Error(w, "unknown sid", http.StatusBadRequest)
+				return
+			}
+			if c, err := r.Cookie("AWSALB"); err != nil || c.Value != "sticky" {
+				http.Error(w, "missing sticky cookie", http.StatusBadRequest)
+				return
+			}
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			for _, expected := range []string{"2probe", "5"} {
+				_, b, err := ws.ReadMessage()
+				if err != nil || string(b) != expected {
+					t.Errorf("unexpected upgrade message. expected: %v, but got: %s", expected, b)
+					return
+				}
+				if expected == "2probe" {
+					ws.WriteMessage(websocket.TextMessage, []byte("3probe"))
+				}
+			}
+			ws.WriteMessage(websocket.TextMessage, []byte(`42["hello"]`))
+			ws.ReadMessage()
+		}
+	}))
+	closed := make(chan struct{}, 2)
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	var dials int32
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	u, _ := GetURL(strings.TrimPrefix(ts.URL, "http://"))
+	conn, err := NewConn(u.String(), WithDialer(dialer), WithPollingHandshake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Errorf("unexpected dials by the dialer. expected: 2, but got: %v", n)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("polling connection is left open")
+	}
+	for _, expected := range []string{handshake, "40", `42["hello"]`} {
+		r, err := conn.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != expected {
+			t.Errorf("unexpected message. expected: %v, but got: %s", expected, b)
+		}
+	}
+}
+
+func TestNewConn_HandshakeError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid token", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	u, _ := GetURL(strings.TrimPrefix(ts.URL, "http://"))
+	for _, opts := range [][]ConnOption{nil, {WithPollingHandshake}} {
+		_, err := NewConn(u.String(), opts...)
+		var herr *HandshakeError
+		if !errors.As(err, &herr) {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if herr.StatusCode != http.StatusForbidden || string(herr.Body) != "invalid token" {
+			t.Errorf("unexpected handshake error: %v", herr)
+		}
+	}
+}
@@ -28,6 +28,11 @@ func (w *prefixWriter) Flush() error {
 	return w.wf.Flush()
 }
 
+func (w *prefixWriter) Discard() {
+	Discard(w.wf)
+	w.init = true
+}
+
 func NewPrefixWriter(wf WriteFlusher, prefix []byte) WriteFlusher {
 	return &prefixWriter{
 		wf:     wf,
@@ -0,0 +1,344 @@
+package gomasio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	Inbound  Direction = "in"
+	Outbound Direction = "out"
+)
+
+// Frame is a recorded message. Data of a binary message is base64 encoded.
+type Frame struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Binary    bool      `json:"binary,omitempty"`
+	Data      string    `json:"data"`
+}
+
+func (f *Frame) bytes() ([]byte, error) {
+	if f.Binary {
+		return base64.StdEncoding.DecodeString(f.Data)
+	}
+	return []byte(f.Data), nil
+}
+
+type recordConn struct {
+	conn Conn
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecordConn returns a Conn that writes every frame passing through conn to w as JSON lines.
+func NewRecordConn(conn Conn, w io.Writer) Conn {
+	return &recordConn{
+		conn: conn,
+		enc:  json.NewEncoder(w),
+	}
+}
+
+func (c *recordConn) record(dir Direction, mt MessageType, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	f := &Frame{
+		Time:      time.Now(),
+		Direction: dir,
+		Data:      string(data),
+	}
+	if mt == BinaryMessage {
+		f.Binary = true
+		f.Data = base64.StdEncoding.EncodeToString(data)
+	}
+	c.err = c.enc.Encode(f)
+}
+
+func (c *recordConn) NextReader() (io.Reader, error) {
+	r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.record(Inbound, TextMessage, b)
+	return bytes.NewReader(b), nil
+}
+
+func (c *recordConn) NextMessage() (MessageType, io.Reader, error) {
+	mt, r, err := NextMessage(c.conn)
+	if err != nil {
+		return 0, nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.record(Inbound, mt, b)
+	return mt, bytes.NewReader(b), nil
+}
+
+func (c *recordConn) NewWriter() WriteFlusher {
+	return &recordWriter{c: c, mt: TextMessage, wf: c.conn.NewWriter()}
+}
+
+func (c *recordConn) NewMessageWriter(mt MessageType) (WriteFlusher, error) {
+	wf, err := NewMessageWriter(c.conn, mt)
+	if err != nil {
+		return nil, err
+	}
+	return &recordWriter{c: c, mt: mt, wf: wf}, nil
+}
+
+func (c *recordConn) SetReadDeadline(t time.Time) error {
+	d, ok := c.conn.(interface{ SetReadDeadline(t time.Time) error })
+	if !ok {
+		return errors.New("read deadline is not supported")
+	}
+	return d.SetReadDeadline(t)
+}
+
+func (c *recordConn) ProtocolVersion() int {
+	return ProtocolVersion(c.conn)
+}
+
+func (c *recordConn) Done() <-chan struct{} {
+	return Done(c.conn)
+}
+
+func (c *recordConn) Err() error {
+	if n, ok := c.conn.(CloseNotifier); ok {
+		return n.Err()
+	}
+	return nil
+}
+
+func (c *recordConn) Close() error {
+	err := c.conn.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && c.err != nil {
+		err = fmt.Errorf("record: %w", c.err)
+	}
+	return err
+}
+
+type recordWriter struct {
+	c   *recordConn
+	mt  MessageType
+	wf  WriteFlusher
+	buf bytes.Buffer
+}
+
+func (w *recordWriter) Write(p []byte) (n int, err error) {
+	w.buf.Write(p)
+	return w.wf.Write(p)
+}
+
+func (w *recordWriter) Flush() error {
+	w.c.record(Outbound, w.mt, w.buf.Bytes())
+	w.buf.Reset()
+	return w.wf.Flush()
+}
+
+func (w *recordWriter) TryFlush() error {
+	err := TryFlush(w.wf)
+	if err == nil {
+		w.c.record(Outbound, w.mt, w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+func (w *recordWriter) Discard() {
+	Discard(w.wf)
+	w.buf.Reset()
+}
+
+type ReplayOptions struct {
+	Speed  float64
+	Output io.Writer
+}
+
+type ReplayOption func(o *ReplayOptions)
+
+// WithSpeed scales the original timing. Speed <= 0 replays without waiting.
+func WithSpeed(speed float64) ReplayOption {
+	return func(o *ReplayOptions) {
+		o.Speed = speed
+	}
+}
+
+func WithReplayOutput(w io.Writer) ReplayOption {
+	return func(o *ReplayOptions) {
+		o.Output = w
+	}
+}
+
+type replayConn struct {
+	frames []Frame
+	speed  float64
+	out    io.Writer
+
+	mu   sync.Mutex
+	last time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReplayConn returns a Conn that serves the inbound frames of a recording made by NewRecordConn.
+func NewReplayConn(r io.Reader, opts ...ReplayOption) (Conn, error) {
+	options := &ReplayOptions{
+		Speed:  1,
+		Output: ioutil.Discard,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	var frames []Frame
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<30)
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var f Frame
+		if err := json.Unmarshal(s.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("decode frame(line=%v): %w", len(frames)+1, err)
+		}
+		if f.Direction == Inbound {
+			frames = append(frames, f)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	return &replayConn{
+		frames: frames,
+		speed:  options.Speed,
+		out:    options.Output,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (c *replayConn) NextReader() (io.Reader, error) {
+	mt, r, err := c.NextMessage()
+	if err != nil {
+		return nil, err
+	}
+	if mt != TextMessage {
+		return nil, fmt.Errorf("currently supports only text message: %v", mt)
+	}
+	return r, nil
+}
+
+func (c *replayConn) NextMessage() (MessageType, io.Reader, error) {
+	if err := c.Err(); err != nil {
+		return 0, nil, err
+	}
+	c.mu.Lock()
+	if len(c.frames) == 0 {
+		c.mu.Unlock()
+		return 0, nil, io.EOF
+	}
+	f := c.frames[0]
+	c.frames = c.frames[1:]
+	var wait time.Duration
+	if !c.last.IsZero() && c.speed > 0 {
+		wait = time.Duration(float64(f.Time.Sub(c.last)) / c.speed)
+	}
+	c.last = f.Time
+	c.mu.Unlock()
+
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-c.done:
+			return 0, nil, ErrClosed
+		}
+	}
+	b, err := f.bytes()
+	if err != nil {
+		return 0, nil, fmt.Errorf("decode binary frame: %w", err)
+	}
+	mt := TextMessage
+	if f.Binary {
+		mt = BinaryMessage
+	}
+	return mt, bytes.NewReader(b), nil
+}
+
+func (c *replayConn) NewWriter() WriteFlusher {
+	return &replayWriter{c: c}
+}
+
+func (c *replayConn) NewMessageWriter(mt MessageType) (WriteFlusher, error) {
+	return &replayWriter{c: c, binary: mt == BinaryMessage}, nil
+}
+
+func (c *replayConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+func (c *replayConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *replayConn) Err() error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+type replayWriter struct {
+	c      *replayConn
+	binary bool
+	buf    bytes.Buffer
+}
+
+func (w *replayWriter) Write(p []byte) (n int, err error) {
+	return w.buf.Write(p)
+}
+
+func (w *replayWriter) Flush() error {
+	if err := w.c.Err(); err != nil {
+		return err
+	}
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	if w.binary {
+		s := base64.StdEncoding.EncodeToString(w.buf.Bytes())
+		w.buf.Reset()
+		w.buf.WriteString(s)
+	}
+	w.buf.WriteByte('\n')
+	_, err := w.buf.WriteTo(w.c.out)
+	return err
+}
@@ -0,0 +1,82 @@
+package gomasio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type testConn struct {
+	frames  []string
+	written bytes.Buffer
+}
+
+func (c *testConn) NextReader() (io.Reader, error) {
+	if len(c.frames) == 0 {
+		return nil, io.EOF
+	}
+	s := c.frames[0]
+	c.frames = c.frames[1:]
+	return strings.NewReader(s), nil
+}
+
+func (c *testConn) NewWriter() WriteFlusher {
+	return NopFlusher(&c.written)
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func TestRecordConn_Replay(t *testing.T) {
+	var rec bytes.Buffer
+	conn := NewRecordConn(&testConn{frames: []string{"0{}", "40", `42["hello"]`}}, &rec)
+	for i := 0; i < 3; i++ {
+		r, err := conn.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(r)
+		if i == 1 {
+			wf := conn.NewWriter()
+			io.WriteString(wf, "2")
+			wf.Flush()
+		}
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(rec.String(), "\n"); got != 4 {
+		t.Fatalf("unexpected recorded frames. expected: 4, but got: %v", got)
+	}
+
+	var out bytes.Buffer
+	replay, err := NewReplayConn(&rec, WithSpeed(0), WithReplayOutput(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		r, err := replay.NextReader()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		got = append(got, string(b))
+	}
+	if expected := `0{} 40 42["hello"]`; strings.Join(got, " ") != expected {
+		t.Errorf("unexpected replayed frames. expected: %v, but got: %v", expected, got)
+	}
+
+	wf := replay.NewWriter()
+	io.WriteString(wf, "3")
+	wf.Flush()
+	if got := out.String(); got != "3\n" {
+		t.Errorf("unexpected replay output. expected: 3, but got: %v", got)
+	}
+}
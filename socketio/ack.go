@@ -0,0 +1,95 @@
+package socketio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ackKey identifies a pending ack. The server acks in the namespace of the event.
+type ackKey struct {
+	namespace string
+	id        int
+}
+
+type ackResult struct {
+	args []json.RawMessage
+	err  error
+}
+
+// ackRegistry holds the pending acks of a connection.
+type ackRegistry struct {
+	mu      sync.Mutex
+	nextID  int
+	waiters map[ackKey]chan ackResult
+	err     error
+}
+
+func newAckRegistry() *ackRegistry {
+	return &ackRegistry{
+		waiters: make(map[ackKey]chan ackResult),
+	}
+}
+
+func (r *ackRegistry) register(namespace string) (int, <-chan ackResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextID
+	r.nextID++
+	ch := make(chan ackResult, 1)
+	if r.err != nil {
+		ch <- ackResult{err: r.err}
+		return id, ch
+	}
+	r.waiters[ackKey{namespace, id}] = ch
+	return id, ch
+}
+
+// fail completes the pending acks of namespace with err.
+func (r *ackRegistry) fail(namespace string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, ch := range r.waiters {
+		if key.namespace == namespace {
+			ch <- ackResult{err: err}
+			delete(r.waiters, key)
+		}
+	}
+}
+
+// close completes the pending and the future acks with err.
+func (r *ackRegistry) close(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	for key, ch := range r.waiters {
+		ch <- ackResult{err: err}
+		delete(r.waiters, key)
+	}
+}
+
+func (r *ackRegistry) cancel(namespace string, id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.waiters, ackKey{namespace, id})
+}
+
+func (r *ackRegistry) resolve(namespace string, id int, body io.Reader) (bool, error) {
+	r.mu.Lock()
+	key := ackKey{namespace, id}
+	ch, ok := r.waiters[key]
+	delete(r.waiters, key)
+	r.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	var args []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&args); err != nil && err != io.EOF {
+		err = fmt.Errorf("decode ack: %w", err)
+		ch <- ackResult{err: fmt.Errorf("invalid ack(id=%v): %w", id, err)}
+		return true, err
+	}
+	ch <- ackResult{args: args}
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package socketio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func BenchmarkContext_Emit(b *testing.B) {
+	ctx, err := NewContext(&testWriterFactory{ioutil.Discard}, &Packet{Namespace: "/chat"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ctx.Emit("message", "hello", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHandler_Receive(b *testing.B) {
+	h := OverEngineIO(HandleFunc(func(ctx Context) {
+		var s string
+		var n int
+		if err := ctx.Args(&s, &n); err != nil {
+			b.Fatal(err)
+		}
+	}))
+	wf := &testWriterFactory{ioutil.Discard}
+	body := []byte(`2/chat,["message","hello",1]`)
+	r := bytes.NewReader(body)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(body)
+		h.HandleMessage(wf, r)
+	}
+}
+
+func BenchmarkHandler_Route(b *testing.B) {
+	mux := NewEventMux()
+	mux.HandleFunc("message", func(ctx Context) {})
+	h := OverEngineIO(mux)
+	wf := &testWriterFactory{ioutil.Discard}
+	body := []byte(`2/chat,["message","hello",{"users":[1,2,3],"text":"a long payload that routing does not decode"}]`)
+	r := bytes.NewReader(body)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(body)
+		h.HandleMessage(wf, r)
+	}
+}
@@ -0,0 +1,45 @@
+package socketio
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// maxPooledBufferSize keeps a few huge messages from pinning memory in the pool.
+const maxPooledBufferSize = 64 << 10
+
+// encodeBuffer is a pooled buffer with a JSON encoder writing into it.
+type encodeBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := &encodeBuffer{}
+		b.enc = json.NewEncoder(&b.Buffer)
+		return b
+	},
+}
+
+func getEncodeBuffer() *encodeBuffer {
+	return encodeBufferPool.Get().(*encodeBuffer)
+}
+
+func putEncodeBuffer(b *encodeBuffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	encodeBufferPool.Put(b)
+}
+
+// encodeValue appends the JSON encoding of v without the newline added by json.Encoder.
+func (b *encodeBuffer) encodeValue(v interface{}) error {
+	if err := b.enc.Encode(v); err != nil {
+		return err
+	}
+	b.Truncate(b.Len() - 1)
+	return nil
+}
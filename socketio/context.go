@@ -1,13 +1,21 @@
 package socketio
 
 import (
+	"bytes"
+	stdctx "context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"sync"
+	"time"
 
 	"github.com/orisano/gomasio"
 )
 
+// Context is a received socket.io packet and the connection it came from.
+// Further features are optional interfaces of a Context: Contexter, Acker, ArgAccessor, ErrorReporter and NamespaceConnector.
 type Context interface {
 	PacketType() PacketType
 	Namespace() string
@@ -20,26 +28,141 @@ type Context interface {
 	Disconnect() error
 }
 
+// Contexter is a Context carrying the context.Context of its connection, with the trace extracted from the event if any.
+type Contexter interface {
+	Context() stdctx.Context
+}
+
+// StdContext returns the context.Context carried by c, or context.Background() if c is not a Contexter.
+func StdContext(c Context) stdctx.Context {
+	if x, ok := c.(Contexter); ok {
+		return x.Context()
+	}
+	return stdctx.Background()
+}
+
+// Acker is a Context which can emit an event and wait for its ack.
+type Acker interface {
+	EmitWithAck(ctx stdctx.Context, event string, args ...interface{}) ([]json.RawMessage, error)
+}
+
+// EmitWithAck emits an event by c and waits for its ack. It fails with ErrAckUnsupported if c is not an Acker.
+func EmitWithAck(c Context, ctx stdctx.Context, event string, args ...interface{}) ([]json.RawMessage, error) {
+	if a, ok := c.(Acker); ok {
+		return a.EmitWithAck(ctx, event, args...)
+	}
+	return nil, ErrAckUnsupported
+}
+
+// ArgAccessor is a Context giving access to the event arguments by index.
+type ArgAccessor interface {
+	ArgCount() int
+	Arg(i int) json.RawMessage
+	ArgInto(i int, dst interface{}) error
+	ScanArgs(dst ...interface{}) error
+}
+
+// ErrorReporter is a Context reporting the ERROR packet sent by the server.
+type ErrorReporter interface {
+	Err() error
+}
+
+// Err returns the *ServerError of an ERROR packet received by c, or nil if c is not an ErrorReporter.
+func Err(c Context) error {
+	if r, ok := c.(ErrorReporter); ok {
+		return r.Err()
+	}
+	return nil
+}
+
+// NamespaceConnector is a Context which can connect to other namespaces of its connection.
+type NamespaceConnector interface {
+	ConnectNamespace(namespace string, auth interface{}) error
+}
+
+// ConnectNamespace connects to namespace by c. It fails with ErrConnectUnsupported if c is not a NamespaceConnector.
+func ConnectNamespace(c Context, namespace string, auth interface{}) error {
+	if n, ok := c.(NamespaceConnector); ok {
+		return n.ConnectNamespace(namespace, auth)
+	}
+	return ErrConnectUnsupported
+}
+
+var (
+	ErrAckUnsupported     = errors.New("ack is not supported on this context")
+	ErrConnectUnsupported = errors.New("connecting namespaces is not supported on this context")
+)
+
+type contextConfig struct {
+	metrics    gomasio.Metrics
+	tracer     Tracer
+	propagator Propagator
+}
+
 func NewContext(wf gomasio.WriterFactory, packet *Packet) (Context, error) {
-	ctx := &context{
+	return newContext(stdctx.Background(), wf, packet, &contextConfig{
+		metrics: gomasio.NopMetrics(),
+		tracer:  nopTracer{},
+	})
+}
+
+func newContext(ctx stdctx.Context, wf gomasio.WriterFactory, packet *Packet, config *contextConfig) (*context, error) {
+	c := &context{
+		ctx:    ctx,
 		wf:     wf,
 		packet: packet,
+		config: config,
 	}
 	if packet.Type == EVENT {
-		var e Event
-		if err := json.NewDecoder(packet.Body).Decode(&e); err != nil {
+		raw, err := ioutil.ReadAll(packet.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read event: %w", err)
+		}
+		name, err := eventName(raw)
+		if err != nil {
 			return nil, fmt.Errorf("decode event: %w", err)
 		}
-		ctx.event = &e
+		c.raw = raw
+		c.event = name
+		if config.propagator != nil {
+			if _, err := c.parseArgs(); err != nil {
+				return nil, err
+			}
+			if c.carrier != nil {
+				c.ctx = config.propagator.Extract(c.ctx, c.carrier)
+			}
+		}
 	}
-	return ctx, nil
+	if packet.Type == ERROR {
+		e, err := parseServerError(packet.Namespace, packet.Body)
+		if err != nil {
+			return nil, err
+		}
+		c.err = e
+	}
+	return c, nil
 }
 
 type context struct {
+	ctx    stdctx.Context
 	wf     gomasio.WriterFactory
 	packet *Packet
+	config *contextConfig
+	state  *connState
 
-	event *Event
+	// raw is the body of an EVENT packet. The arguments are decoded on first access.
+	raw      []byte
+	event    string
+	argsOnce sync.Once
+	args     []json.RawMessage
+	argsErr  error
+	carrier  map[string]string
+
+	err *ServerError
+}
+
+func (c *context) Context() stdctx.Context {
+	return c.ctx
 }
 
 func (c *context) PacketType() PacketType {
@@ -51,65 +174,218 @@ func (c *context) Namespace() string {
 }
 
 func (c *context) Body() io.Reader {
+	if c.raw != nil {
+		return bytes.NewReader(c.raw)
+	}
 	return c.packet.Body
 }
 
+// Event returns the event name. It is empty unless the packet is an EVENT.
 func (c *context) Event() string {
-	return c.event.Name
+	return c.event
 }
 
+func (c *context) parseArgs() ([]json.RawMessage, error) {
+	c.argsOnce.Do(func() {
+		if c.raw == nil {
+			return
+		}
+		var e Event
+		if err := json.Unmarshal(c.raw, &e); err != nil {
+			c.argsErr = fmt.Errorf("decode event: %w", err)
+			return
+		}
+		c.args = e.Args
+		if c.config.propagator != nil {
+			c.args, c.carrier = splitMetadata(c.args)
+		}
+	})
+	return c.args, c.argsErr
+}
+
+// Args decodes the arguments into dst. The number of dst must match the number of the arguments.
 func (c *context) Args(dst ...interface{}) error {
-	if len(dst) != len(c.event.Args) {
+	args, err := c.parseArgs()
+	if err != nil {
+		return err
+	}
+	if len(dst) != len(args) {
 		return fmt.Errorf("not match args length")
 	}
 	for i := range dst {
-		if err := json.Unmarshal(c.event.Args[i], dst[i]); err != nil {
+		if err := json.Unmarshal(args[i], dst[i]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// ArgCount returns the number of the arguments. It returns 0 if the event is invalid.
+func (c *context) ArgCount() int {
+	args, _ := c.parseArgs()
+	return len(args)
+}
+
+// Arg returns the i-th argument as is. It returns nil if i is out of range or the event is invalid.
+func (c *context) Arg(i int) json.RawMessage {
+	args, _ := c.parseArgs()
+	if i < 0 || len(args) <= i {
+		return nil
+	}
+	return args[i]
+}
+
+// ArgInto decodes the i-th argument into dst.
+func (c *context) ArgInto(i int, dst interface{}) error {
+	args, err := c.parseArgs()
+	if err != nil {
+		return err
+	}
+	if i < 0 || len(args) <= i {
+		return fmt.Errorf("arg index out of range(index=%v, count=%v)", i, len(args))
+	}
+	return json.Unmarshal(args[i], dst)
+}
+
+// ScanArgs decodes the arguments into dst in order, without requiring the numbers to match.
+// dst without the corresponding argument is left untouched, the arguments beyond dst are ignored
+// and a nil dst skips its argument.
+func (c *context) ScanArgs(dst ...interface{}) error {
+	args, err := c.parseArgs()
+	if err != nil {
+		return err
+	}
+	for i, d := range dst {
+		if len(args) <= i {
+			break
+		}
+		if d == nil {
+			continue
+		}
+		if err := json.Unmarshal(args[i], d); err != nil {
+			return fmt.Errorf("decode arg(index=%v): %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Err returns the *ServerError carried by an ERROR packet.
+func (c *context) Err() error {
+	if c.err == nil {
+		return nil
+	}
+	return c.err
+}
+
+func (c *context) checkConnected() error {
+	if c.state == nil {
+		return nil
+	}
+	return c.state.check(c.packet.Namespace)
+}
+
 func (c *context) Emit(event string, args ...interface{}) error {
-	e := &Event{
-		Name: event,
-	}
-	argc := len(args)
-	if argc > 0 {
-		e.Args = make([]json.RawMessage, 0, argc)
-		for _, arg := range args {
-			b, err := json.Marshal(arg)
-			if err != nil {
-				return fmt.Errorf("marshal args: %w", err)
-			}
-			e.Args = append(e.Args, b)
+	if err := c.checkConnected(); err != nil {
+		return err
+	}
+	ctx, span := c.config.tracer.Start(c.ctx, SpanEmit, c.packet.Namespace, event)
+	err := c.emit(ctx, -1, event, args)
+	span.End(err)
+	return err
+}
+
+func (c *context) EmitWithAck(ctx stdctx.Context, event string, args ...interface{}) (res []json.RawMessage, err error) {
+	if c.state == nil {
+		return nil, ErrAckUnsupported
+	}
+	acks := c.state.acks
+	if err := c.checkConnected(); err != nil {
+		return nil, err
+	}
+	ctx, span := c.config.tracer.Start(ctx, SpanEmit, c.packet.Namespace, event)
+	defer func() {
+		span.End(err)
+	}()
+
+	id, ch := acks.register(c.packet.Namespace)
+	start := time.Now()
+	if err := c.emit(ctx, id, event, args); err != nil {
+		acks.cancel(c.packet.Namespace, id)
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		c.config.metrics.AckLatency(time.Since(start))
+		return res.args, nil
+	case <-ctx.Done():
+		acks.cancel(c.packet.Namespace, id)
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		acks.cancel(c.packet.Namespace, id)
+		return nil, disconnectedError(c.packet.Namespace, ReasonTransportClose)
+	}
+}
+
+func (c *context) emit(ctx stdctx.Context, id int, event string, args []interface{}) error {
+	if c.config.propagator != nil {
+		carrier := make(map[string]string)
+		c.config.propagator.Inject(ctx, carrier)
+		if len(carrier) > 0 {
+			args = append(args[:len(args):len(args)], metadata(carrier))
 		}
 	}
 
 	p := Packet{
 		Type:      EVENT,
 		Namespace: c.packet.Namespace,
-		ID:        -1,
+		ID:        id,
 	}
 	wf := c.wf.NewWriter()
-	if err := NewEncoder(wf).Encode(&p); err != nil {
-		return fmt.Errorf("encode header: %w", err)
-	}
-	if err := json.NewEncoder(wf).Encode(e); err != nil {
+	if err := NewEncoder(wf).EncodeEvent(&p, event, args...); err != nil {
 		return fmt.Errorf("encode event: %w", err)
 	}
-	return wf.Flush()
+	if err := wf.Flush(); err != nil {
+		return err
+	}
+	c.config.metrics.PacketSent("socket.io", EVENT.String())
+	return nil
+}
+
+// ConnectNamespace requests to connect namespace on the connection of c, sending auth unless it is nil.
+// The handler receives the CONNECT packet of namespace once the server accepts it.
+func (c *context) ConnectNamespace(namespace string, auth interface{}) error {
+	p, err := NewConnectPacket(namespace, auth)
+	if err != nil {
+		return err
+	}
+	wf := c.wf.NewWriter()
+	if err := NewEncoder(wf).Encode(p); err != nil {
+		return err
+	}
+	if err := wf.Flush(); err != nil {
+		return err
+	}
+	c.config.metrics.PacketSent("socket.io", CONNECT.String())
+	return nil
 }
 
 func (c *context) Disconnect() error {
+	if err := c.checkConnected(); err != nil {
+		return err
+	}
 	wf := c.wf.NewWriter()
-	p := Packet{
-		Type:      DISCONNECT,
-		Namespace: c.packet.Namespace,
-		ID:        -1,
+	if err := NewEncoder(wf).Encode(NewDisconnectPacket(c.packet.Namespace)); err != nil {
+		return err
 	}
-	if err := NewEncoder(wf).Encode(&p); err != nil {
+	if err := wf.Flush(); err != nil {
 		return err
 	}
-	return wf.Flush()
+	c.config.metrics.PacketSent("socket.io", DISCONNECT.String())
+	if c.state != nil {
+		c.state.disconnect(c.packet.Namespace, ReasonClientDisconnect)
+	}
+	return nil
 }
@@ -2,10 +2,16 @@ package socketio
 
 import (
 	"bytes"
+	stdctx "context"
+	"encoding/json"
+	"errors"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/orisano/gomasio"
+	"github.com/orisano/gomasio/engineio"
 )
 
 type testWriterFactory struct {
@@ -66,6 +72,20 @@ func TestContext_Emit(t *testing.T) {
 	}
 }
 
+func TestContext_ConnectNamespace(t *testing.T) {
+	var b bytes.Buffer
+	ctx, err := NewContext(&testWriterFactory{&b}, &Packet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ConnectNamespace(ctx, "/chat", map[string]string{"token": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := b.String(), `0/chat,{"token":"x"}`; got != expected {
+		t.Errorf("unexpected connect packet. expected: %v, but got: %v", expected, got)
+	}
+}
+
 func TestContext_Event(t *testing.T) {
 	b := new(bytes.Buffer)
 	ts := []struct {
@@ -80,6 +100,14 @@ func TestContext_Event(t *testing.T) {
 			body:     `["reply",1,2,3,4,5,6,7]`,
 			expected: "reply",
 		},
+		{
+			body:     ` [ "say \"hi\"", 1]`,
+			expected: `say "hi"`,
+		},
+		{
+			body:     `["route",{invalid`,
+			expected: "route",
+		},
 	}
 	for _, tc := range ts {
 		ctx, err := NewContext(&testWriterFactory{b}, &Packet{
@@ -122,3 +150,308 @@ func TestContext_Args(t *testing.T) {
 		t.Errorf("unexpected d['dict']. expected: 1, but got: %v", got)
 	}
 }
+
+func TestContext_ArgAccess(t *testing.T) {
+	b := new(bytes.Buffer)
+	ctx, err := NewContext(&testWriterFactory{b}, &Packet{
+		Type: EVENT,
+		Body: bytes.NewBufferString(`["sample",1,"test",{"dict":1}]`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := ctx.(ArgAccessor)
+	if got := args.ArgCount(); got != 3 {
+		t.Errorf("unexpected arg count. expected: 3, but got: %v", got)
+	}
+	if got := string(args.Arg(1)); got != `"test"` {
+		t.Errorf("unexpected arg. expected: \"test\", but got: %v", got)
+	}
+	if got := args.Arg(3); got != nil {
+		t.Errorf("unexpected arg out of range: %s", got)
+	}
+	var d map[string]int
+	if err := args.ArgInto(2, &d); err != nil || d["dict"] != 1 {
+		t.Errorf("unexpected ArgInto result. d: %v, err: %v", d, err)
+	}
+	if err := args.ArgInto(3, &d); err == nil {
+		t.Error("expected out of range error")
+	}
+
+	var s string
+	extra := "untouched"
+	if err := args.ScanArgs(nil, &s, nil, &extra); err != nil {
+		t.Fatal(err)
+	}
+	if s != "test" || extra != "untouched" {
+		t.Errorf("unexpected ScanArgs result. s: %v, extra: %v", s, extra)
+	}
+	var i int
+	if err := args.ScanArgs(&i); err != nil || i != 1 {
+		t.Errorf("unexpected ScanArgs result. i: %v, err: %v", i, err)
+	}
+}
+
+func TestContext_LazyArgs(t *testing.T) {
+	b := new(bytes.Buffer)
+	ctx, err := NewContext(&testWriterFactory{b}, &Packet{
+		Type: EVENT,
+		Body: bytes.NewBufferString(`["route",{invalid`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := ctx.(ArgAccessor)
+	if got := ctx.Event(); got != "route" {
+		t.Errorf("unexpected event name. expected: route, but got: %v", got)
+	}
+	if err := args.ScanArgs(); err == nil {
+		t.Error("expected decode error of invalid args")
+	}
+	if got := args.ArgCount(); got != 0 {
+		t.Errorf("unexpected arg count of invalid args: %v", got)
+	}
+}
+
+func TestContext_EmitWithAck(t *testing.T) {
+	w := &syncBuffer{written: make(chan string, 1)}
+	wf := &testWriterFactory{w}
+	ctxc := make(chan Context, 1)
+	h := OverEngineIO(HandleFunc(func(ctx Context) {
+		ctxc <- ctx
+	}))
+	conn := openTestConn(stdctx.Background(), h, wf)
+	conn.receive("0")
+	ctx := <-ctxc
+
+	type result struct {
+		args []json.RawMessage
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		args, err := EmitWithAck(ctx, stdctx.Background(), "ping", 1)
+		resc <- result{args, err}
+	}()
+	if got, expected := <-w.written, `20["ping",1]`+"\n"; got != expected {
+		t.Fatalf("unexpected emit event. expected: %v, but got: %v", expected, got)
+	}
+	conn.receive(`30["pong",2]`)
+	res := <-resc
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if len(res.args) != 2 || string(res.args[0]) != `"pong"` || string(res.args[1]) != "2" {
+		t.Errorf("unexpected ack args: %s", res.args)
+	}
+
+	h.HandleMessage(wf, bytes.NewBufferString("0"))
+	if _, err := EmitWithAck(<-ctxc, stdctx.Background(), "ping"); !errors.Is(err, ErrAckUnsupported) {
+		t.Errorf("unexpected error without connection state. expected: %v, but got: %v", ErrAckUnsupported, err)
+	}
+}
+
+func TestContext_EmitWithAckIsolation(t *testing.T) {
+	w := &syncBuffer{written: make(chan string, 1)}
+	ctxc := make(chan Context, 1)
+	h := OverEngineIO(HandleFunc(func(ctx Context) {
+		if ctx.PacketType() == CONNECT {
+			ctxc <- ctx
+		}
+	}))
+	conn := openTestConn(stdctx.Background(), h, &testWriterFactory{w})
+	other := openTestConn(stdctx.Background(), h, &testWriterFactory{new(bytes.Buffer)})
+	conn.receive("0/chat,")
+	ctx := <-ctxc
+
+	resc := make(chan []json.RawMessage, 1)
+	go func() {
+		args, _ := EmitWithAck(ctx, stdctx.Background(), "ping")
+		resc <- args
+	}()
+	<-w.written
+	other.receive(`3/chat,0["other connection"]`)
+	conn.receive(`30["other namespace"]`)
+	select {
+	case args := <-resc:
+		t.Fatalf("ack resolved by another connection or namespace: %s", args)
+	default:
+	}
+	conn.receive(`3/chat,0["pong"]`)
+	if args := <-resc; len(args) != 1 || string(args[0]) != `"pong"` {
+		t.Errorf("unexpected ack args: %s", args)
+	}
+}
+
+func TestContext_EmitWithAckDisconnected(t *testing.T) {
+	ts := []struct {
+		name  string
+		close func(conn *testConn, cancel stdctx.CancelFunc)
+	}{
+		{
+			name: "namespace",
+			close: func(conn *testConn, cancel stdctx.CancelFunc) {
+				conn.receive("1/chat,")
+			},
+		},
+		{
+			name: "connection",
+			close: func(conn *testConn, cancel stdctx.CancelFunc) {
+				conn.close()
+			},
+		},
+		{
+			name: "context",
+			close: func(conn *testConn, cancel stdctx.CancelFunc) {
+				cancel()
+			},
+		},
+	}
+	for _, tc := range ts {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &syncBuffer{written: make(chan string, 1)}
+			ctxc := make(chan Context, 1)
+			h := OverEngineIO(HandleFunc(func(ctx Context) {
+				if ctx.PacketType() == CONNECT {
+					ctxc <- ctx
+				}
+			}))
+			connCtx, cancel := stdctx.WithCancel(stdctx.Background())
+			defer cancel()
+			conn := openTestConn(connCtx, h, &testWriterFactory{w})
+			conn.receive("0/chat,")
+			ctx := <-ctxc
+
+			errc := make(chan error, 1)
+			go func() {
+				_, err := EmitWithAck(ctx, stdctx.Background(), "ping")
+				errc <- err
+			}()
+			<-w.written
+			tc.close(conn, cancel)
+			select {
+			case err := <-errc:
+				if !errors.Is(err, ErrDisconnected) {
+					t.Errorf("unexpected error. expected: %v, but got: %v", ErrDisconnected, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("EmitWithAck did not return")
+			}
+		})
+	}
+}
+
+type syncBuffer struct {
+	buf     bytes.Buffer
+	written chan string
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.buf.Write(p)
+	if bytes.HasSuffix(p, []byte("\n")) {
+		b.written <- b.buf.String()
+		b.buf.Reset()
+	}
+	return len(p), nil
+}
+
+type pipeConn struct {
+	frames  chan string
+	written chan string
+	closed  chan struct{}
+}
+
+func (c *pipeConn) NextReader() (io.Reader, error) {
+	select {
+	case s := <-c.frames:
+		return strings.NewReader(s), nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *pipeConn) NewWriter() gomasio.WriteFlusher {
+	return &pipeWriter{conn: c}
+}
+
+func (c *pipeConn) Close() error {
+	return nil
+}
+
+type pipeWriter struct {
+	conn *pipeConn
+	buf  bytes.Buffer
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *pipeWriter) Flush() error {
+	w.conn.written <- w.buf.String()
+	return nil
+}
+
+func TestContext_EmitWithAckDispatchPool(t *testing.T) {
+	pool := engineio.NewDispatchPool(1, 1)
+	defer pool.Close()
+	conn := &pipeConn{
+		frames:  make(chan string),
+		written: make(chan string, 10),
+		closed:  make(chan struct{}),
+	}
+	defer close(conn.closed)
+
+	errc := make(chan error, 1)
+	h := HandleFunc(func(ctx Context) {
+		if ctx.PacketType() != CONNECT {
+			return
+		}
+		actx, cancel := stdctx.WithTimeout(stdctx.Background(), 2*time.Second)
+		defer cancel()
+		_, err := EmitWithAck(ctx, actx, "ping")
+		errc <- err
+	})
+	go Connect(stdctx.Background(), conn, h, engineio.WithClock(engineio.NewFakeClock(time.Unix(0, 0))), engineio.WithDispatcher(pool))
+	conn.frames <- `0{"sid":"abc","pingInterval":25000,"pingTimeout":5000}`
+	conn.frames <- "40"
+	<-conn.written
+	// The only worker waits for the ack and the filler takes the queue, so the ack cannot be dispatched.
+	conn.frames <- `42["filler"]`
+	conn.frames <- "430[]"
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ack was not resolved while the dispatch pool was busy")
+	}
+}
+
+// baseContext implements only the required methods of Context, as an outside implementation may.
+type baseContext struct{}
+
+func (baseContext) PacketType() PacketType                       { return EVENT }
+func (baseContext) Namespace() string                            { return "/" }
+func (baseContext) Body() io.Reader                              { return bytes.NewReader(nil) }
+func (baseContext) Event() string                                { return "" }
+func (baseContext) Args(dst ...interface{}) error                { return nil }
+func (baseContext) Emit(event string, args ...interface{}) error { return nil }
+func (baseContext) Disconnect() error                            { return nil }
+
+func TestContext_OptionalInterfaces(t *testing.T) {
+	var ctx Context = baseContext{}
+	if got := StdContext(ctx); got != stdctx.Background() {
+		t.Errorf("unexpected context: %v", got)
+	}
+	if _, err := EmitWithAck(ctx, stdctx.Background(), "ping"); !errors.Is(err, ErrAckUnsupported) {
+		t.Errorf("unexpected ack error. expected: %v, but got: %v", ErrAckUnsupported, err)
+	}
+	if err := ConnectNamespace(ctx, "/chat", nil); !errors.Is(err, ErrConnectUnsupported) {
+		t.Errorf("unexpected connect error. expected: %v, but got: %v", ErrConnectUnsupported, err)
+	}
+	if err := Err(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
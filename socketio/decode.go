@@ -2,22 +2,118 @@ package socketio
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"io/ioutil"
+	"math"
+	"strings"
 )
 
 var IllegalAttachmentsError = errors.New("illegal attachments")
 
+var (
+	ErrMalformedPacket = errors.New("malformed packet")
+	ErrLimitExceeded   = errors.New("limit exceeded")
+)
+
+// DecodeError is returned when a packet is malformed or exceeds Limits.
+// It wraps ErrMalformedPacket or ErrLimitExceeded.
+type DecodeError struct {
+	Field string
+	Err   error
+	Msg   string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v: %v: %v", e.Field, e.Err, e.Msg)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func malformed(field, format string, args ...interface{}) error {
+	return &DecodeError{Field: field, Err: ErrMalformedPacket, Msg: fmt.Sprintf(format, args...)}
+}
+
+func limitExceeded(field string, max int) error {
+	return &DecodeError{Field: field, Err: ErrLimitExceeded, Msg: fmt.Sprintf("max %v", max)}
+}
+
+// Limits bounds the packets accepted by a strict decoder. Zero means no limit.
+type Limits struct {
+	MaxNamespaceLength int
+	MaxID              int
+	MaxAttachments     int
+	// MaxDepth is the maximum nesting of the JSON body. The event array itself is depth 1.
+	MaxDepth int
+	// MaxArgs is the maximum number of the arguments of an EVENT or an ACK.
+	MaxArgs int
+}
+
+var DefaultLimits = Limits{
+	MaxNamespaceLength: 256,
+	MaxID:              math.MaxInt32,
+	MaxAttachments:     64,
+	MaxDepth:           32,
+	MaxArgs:            64,
+}
+
+type DecoderOptions struct {
+	Strict bool
+	Limits Limits
+}
+
+type DecoderOption func(o *DecoderOptions)
+
+// StrictDecoding rejects malformed packets and packets exceeding the limits, DefaultLimits unless WithLimits is given.
+// The body is read and validated by Decode.
+func StrictDecoding(o *DecoderOptions) {
+	o.Strict = true
+}
+
+// WithLimits enables strict decoding with l.
+func WithLimits(l Limits) DecoderOption {
+	return func(o *DecoderOptions) {
+		o.Strict = true
+		o.Limits = l
+	}
+}
+
+type byteScanner interface {
+	io.Reader
+	io.ByteScanner
+}
+
 type Decoder struct {
-	r *bufio.Reader
+	r      byteScanner
+	strict bool
+	limits Limits
 }
 
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{
-		r: bufio.NewReader(r),
+// NewDecoder returns a decoder reading from r. r is buffered unless it implements io.ByteScanner.
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	options := &DecoderOptions{
+		Limits: DefaultLimits,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	br, ok := r.(byteScanner)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d := &Decoder{
+		r:      br,
+		strict: options.Strict,
+	}
+	if d.strict {
+		d.limits = options.Limits
 	}
+	return d
 }
 
 func (d *Decoder) Decode() (*Packet, error) {
@@ -56,24 +152,84 @@ func (d *Decoder) Decode() (*Packet, error) {
 	p.ID = id
 
 	p.Body = d.r
+	if d.strict {
+		body, err := ioutil.ReadAll(d.r)
+		if err != nil {
+			return nil, fmt.Errorf("read body: %w", err)
+		}
+		if err := d.validateBody(p.Type, body); err != nil {
+			return nil, fmt.Errorf("validate body: %w", err)
+		}
+		p.Body = bytes.NewReader(body)
+	}
 	return p, nil
 }
 
 func (d *Decoder) parseAttachments() (int, error) {
-	s, err := d.r.ReadString('-')
+	// The count has at most as many digits as MaxAttachments, plus the delimiter.
+	max := 0
+	if d.limits.MaxAttachments > 0 {
+		max = len(fmt.Sprint(d.limits.MaxAttachments)) + 1
+	}
+	s, err := d.readString('-', "", max)
 	if err == io.EOF {
 		return -1, IllegalAttachmentsError
 	}
+	if errors.Is(err, ErrLimitExceeded) {
+		return -1, limitExceeded("attachments", d.limits.MaxAttachments)
+	}
 	if err != nil {
 		return -1, err
 	}
-	attachments, err := strconv.Atoi(s[:len(s)-1])
-	if err != nil {
+	attachments, ok := parseUint(s)
+	if !ok {
 		return -1, IllegalAttachmentsError
 	}
+	if d.limits.MaxAttachments > 0 && attachments > d.limits.MaxAttachments {
+		return -1, limitExceeded("attachments", d.limits.MaxAttachments)
+	}
 	return attachments, nil
 }
 
+func parseUint(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return 0, false
+		}
+		x := int(s[i] - '0')
+		if n > (math.MaxInt32-x)/10 {
+			return 0, false
+		}
+		n = n*10 + x
+	}
+	return n, true
+}
+
+// readString reads until delim and returns prefix followed by the bytes read without delim.
+// It returns the string read so far with io.EOF if delim is not found.
+// If max > 0, it fails with ErrLimitExceeded when the string would be longer than max.
+func (d *Decoder) readString(delim byte, prefix string, max int) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return sb.String(), err
+		}
+		if b == delim {
+			return sb.String(), nil
+		}
+		if max > 0 && sb.Len() >= max {
+			return "", ErrLimitExceeded
+		}
+		sb.WriteByte(b)
+	}
+}
+
 func (d *Decoder) parseNamespace() (string, error) {
 	b, err := d.r.ReadByte()
 	if err == io.EOF {
@@ -86,17 +242,27 @@ func (d *Decoder) parseNamespace() (string, error) {
 		d.r.UnreadByte()
 		return "/", nil
 	}
-	s, err := d.r.ReadString(',')
-	if err == io.EOF {
-		return "/" + s, nil
-	} else if err != nil {
+	s, err := d.readString(',', "/", d.limits.MaxNamespaceLength)
+	if errors.Is(err, ErrLimitExceeded) {
+		return "", limitExceeded("namespace", d.limits.MaxNamespaceLength)
+	}
+	if err != nil && err != io.EOF {
 		return "", err
-	} else {
-		return "/" + s[:len(s)-1], nil
 	}
+	if err == io.EOF && d.strict && strings.ContainsAny(s, `"[{`) {
+		// A namespace without ',' may end the packet, but must not swallow the body.
+		return "", malformed("namespace", "missing ',' in %q", s)
+	}
+	return s, nil
 }
 
+const maxInt = int(^uint(0) >> 1)
+
 func (d *Decoder) parseID() (int, error) {
+	limit := maxInt
+	if d.limits.MaxID > 0 {
+		limit = d.limits.MaxID
+	}
 	id := -1
 	for {
 		b, err := d.r.ReadByte()
@@ -112,9 +278,106 @@ func (d *Decoder) parseID() (int, error) {
 		}
 		x := int(b - '0')
 		if id == -1 {
-			id = x
-		} else {
-			id = id*10 + x
+			id = 0
 		}
+		if id > (limit-x)/10 {
+			if d.limits.MaxID > 0 {
+				return -1, limitExceeded("ID", d.limits.MaxID)
+			}
+			return -1, malformed("ID", "overflow")
+		}
+		id = id*10 + x
+	}
+}
+
+func (d *Decoder) validateBody(t PacketType, body []byte) error {
+	switch t {
+	case EVENT, ACK, BINARY_EVENT, BINARY_ACK:
+	case CONNECT, DISCONNECT:
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil
+		}
+	default:
+		// ERROR may carry plain text.
+		return nil
+	}
+	if !json.Valid(body) {
+		return malformed("body", "invalid JSON")
+	}
+	depth, elems, isArray := jsonShape(body)
+	if d.limits.MaxDepth > 0 && depth > d.limits.MaxDepth {
+		return limitExceeded("depth", d.limits.MaxDepth)
+	}
+	switch t {
+	case EVENT, BINARY_EVENT:
+		if !isArray || elems == 0 {
+			return malformed("body", "event must be a non-empty array")
+		}
+		if _, err := eventName(body); err != nil {
+			return malformed("body", "%v", err)
+		}
+		elems--
+	case ACK, BINARY_ACK:
+		if !isArray {
+			return malformed("body", "ack must be an array")
+		}
+	default:
+		return nil
+	}
+	if d.limits.MaxArgs > 0 && elems > d.limits.MaxArgs {
+		return limitExceeded("args", d.limits.MaxArgs)
+	}
+	return nil
+}
+
+// jsonShape returns the maximum nesting depth of valid JSON b and, if b is an array, the number of its elements.
+func jsonShape(b []byte) (depth, elems int, isArray bool) {
+	level := 0
+	inString, escaped, empty := false, false, true
+	for _, c := range b {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '"':
+			inString = true
+		case '[', '{':
+			if level == 0 {
+				isArray = c == '['
+			}
+			if level == 1 {
+				empty = false
+			}
+			level++
+			if level > depth {
+				depth = level
+			}
+			continue
+		case ']', '}':
+			level--
+			continue
+		case ',':
+			if level == 1 {
+				elems++
+			}
+			continue
+		}
+		if level == 1 {
+			empty = false
+		}
+	}
+	if isArray && !empty {
+		elems++
 	}
+	return depth, elems, isArray
 }
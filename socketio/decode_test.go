@@ -0,0 +1,70 @@
+package socketio
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecoder_Decode(t *testing.T) {
+	ts := []struct {
+		in        string
+		namespace string
+		id        int
+		body      string
+	}{
+		{in: `2["hello"]`, namespace: "/", id: -1, body: `["hello"]`},
+		{in: `2/chat,12["hello"]`, namespace: "/chat", id: 12, body: `["hello"]`},
+		{in: `0/chat`, namespace: "/chat", id: -1},
+	}
+	for _, tc := range ts {
+		p, err := NewDecoder(strings.NewReader(tc.in), StrictDecoding).Decode()
+		if err != nil {
+			t.Errorf("decode %q: %v", tc.in, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(p.Body)
+		if p.Namespace != tc.namespace || p.ID != tc.id || string(body) != tc.body {
+			t.Errorf("unexpected packet of %q. namespace: %v, id: %v, body: %s", tc.in, p.Namespace, p.ID, body)
+		}
+	}
+}
+
+func TestDecoder_Strict(t *testing.T) {
+	limits := Limits{MaxNamespaceLength: 8, MaxID: 1000, MaxAttachments: 4, MaxDepth: 3, MaxArgs: 2}
+	ts := []struct {
+		in       string
+		expected error
+		field    string
+	}{
+		{in: `2/toolongnamespace,["hello"]`, expected: ErrLimitExceeded, field: "namespace"},
+		{in: `21001["hello"]`, expected: ErrLimitExceeded, field: "ID"},
+		{in: `55-["hello"]`, expected: ErrLimitExceeded, field: "attachments"},
+		{in: `2["hello",[[[1]]]]`, expected: ErrLimitExceeded, field: "depth"},
+		{in: `2["hello",1,2,3]`, expected: ErrLimitExceeded, field: "args"},
+		{in: `3[1,2,3]`, expected: ErrLimitExceeded, field: "args"},
+		{in: `2["hello",`, expected: ErrMalformedPacket, field: "body"},
+		{in: `2[1]`, expected: ErrMalformedPacket, field: "body"},
+		{in: `3{}`, expected: ErrMalformedPacket, field: "body"},
+		{in: `2/a["b"]`, expected: ErrMalformedPacket, field: "namespace"},
+	}
+	for _, tc := range ts {
+		_, err := NewDecoder(strings.NewReader(tc.in), WithLimits(limits)).Decode()
+		var de *DecodeError
+		if !errors.Is(err, tc.expected) || !errors.As(err, &de) || de.Field != tc.field {
+			t.Errorf("unexpected error of %q. expected: %v of %v, but got: %v", tc.in, tc.expected, tc.field, err)
+		}
+	}
+
+	if _, err := NewDecoder(strings.NewReader(`2["hello",1,2,3]`)).Decode(); err != nil {
+		t.Errorf("limits must not apply without strict decoding: %v", err)
+	}
+}
+
+func TestDecoder_IDOverflow(t *testing.T) {
+	_, err := NewDecoder(strings.NewReader(`2999999999999999999999["hello"]`)).Decode()
+	if !errors.Is(err, ErrMalformedPacket) {
+		t.Errorf("unexpected error. expected: %v, but got: %v", ErrMalformedPacket, err)
+	}
+}
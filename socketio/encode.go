@@ -1,19 +1,18 @@
 package socketio
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"strconv"
 )
 
 type Encoder struct {
-	w *bufio.Writer
+	w io.Writer
 }
 
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
-		w: bufio.NewWriter(w),
+		w: w,
 	}
 }
 
@@ -21,16 +20,64 @@ func (e *Encoder) Encode(packet *Packet) error {
 	if packet == nil {
 		return fmt.Errorf("missing packet")
 	}
-	e.w.WriteByte(byte(packet.Type) + '0')
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+	writeHeader(buf, packet)
+	if _, err := e.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if packet.Body != nil {
+		if _, err := io.Copy(e.w, packet.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeEvent encodes packet with the event array of name and args as the body.
+// The arguments are marshalled once and the packet is written to w by a single Write call.
+func (e *Encoder) EncodeEvent(packet *Packet, name string, args ...interface{}) error {
+	if packet == nil {
+		return fmt.Errorf("missing packet")
+	}
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+	writeHeader(buf, packet)
+	if err := writeEventBody(buf, name, args); err != nil {
+		return err
+	}
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+func writeHeader(buf *encodeBuffer, packet *Packet) {
+	buf.WriteByte(byte(packet.Type) + '0')
+	if (packet.Type == BINARY_EVENT || packet.Type == BINARY_ACK) && packet.Attachments >= 0 {
+		var b [20]byte
+		buf.Write(strconv.AppendInt(b[:0], int64(packet.Attachments), 10))
+		buf.WriteByte('-')
+	}
 	if len(packet.Namespace) > 0 && packet.Namespace != "/" {
-		e.w.WriteString(packet.Namespace)
-		e.w.WriteByte(',')
+		buf.WriteString(packet.Namespace)
+		buf.WriteByte(',')
 	}
 	if packet.ID >= 0 {
-		e.w.WriteString(strconv.Itoa(packet.ID))
+		var b [20]byte
+		buf.Write(strconv.AppendInt(b[:0], int64(packet.ID), 10))
 	}
-	if packet.Body != nil {
-		io.Copy(e.w, packet.Body)
+}
+
+func writeEventBody(buf *encodeBuffer, name string, args []interface{}) error {
+	buf.WriteByte('[')
+	if err := buf.encodeValue(name); err != nil {
+		return fmt.Errorf("marshal event name: %w", err)
+	}
+	for _, arg := range args {
+		buf.WriteByte(',')
+		if err := buf.encodeValue(arg); err != nil {
+			return fmt.Errorf("marshal args: %w", err)
+		}
 	}
-	return e.w.Flush()
+	buf.WriteString("]\n")
+	return nil
 }
@@ -0,0 +1,57 @@
+package socketio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+var ErrDisconnected = errors.New("namespace disconnected")
+
+// ServerError is the payload of an ERROR packet.
+type ServerError struct {
+	Namespace string
+	Message   string
+	Data      json.RawMessage
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("socket.io server error(namespace=%v): %v", e.Namespace, e.Message)
+}
+
+func parseServerError(namespace string, body io.Reader) (*ServerError, error) {
+	e := &ServerError{Namespace: namespace}
+	if body == nil {
+		return e, nil
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("read error payload: %w", err)
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return e, nil
+	}
+	switch b[0] {
+	case '"':
+		if err := json.Unmarshal(b, &e.Message); err != nil {
+			return nil, fmt.Errorf("decode error payload: %w", err)
+		}
+	case '{':
+		var v struct {
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("decode error payload: %w", err)
+		}
+		e.Message = v.Message
+		e.Data = v.Data
+	default:
+		e.Message = string(b)
+	}
+	return e, nil
+}
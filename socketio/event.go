@@ -2,6 +2,7 @@ package socketio
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -34,8 +35,49 @@ func (e *Event) UnmarshalJSON(p []byte) error {
 		return err
 	}
 	if m > 1 {
-		e.Args = make([]json.RawMessage, m-1)
-		copy(e.Args, msg[1:])
+		e.Args = msg[1:]
 	}
 	return nil
 }
+
+// eventName returns the event name, the first element of the event array, without decoding the rest.
+func eventName(b []byte) (string, error) {
+	i := skipSpace(b, 0)
+	if i >= len(b) || b[i] != '[' {
+		return "", errors.New("event must be an array")
+	}
+	i = skipSpace(b, i+1)
+	if i >= len(b) || b[i] != '"' {
+		return "", errors.New("event name must be a string")
+	}
+	escaped := false
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			escaped = true
+			j++
+		case '"':
+			if !escaped {
+				return string(b[i+1 : j]), nil
+			}
+			var name string
+			if err := json.Unmarshal(b[i:j+1], &name); err != nil {
+				return "", err
+			}
+			return name, nil
+		}
+	}
+	return "", errors.New("unterminated event name")
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) {
+		switch b[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
@@ -1,8 +1,10 @@
 package socketio
 
 import (
+	"bytes"
 	stdctx "context"
 	"io"
+	"time"
 
 	"github.com/orisano/gomasio"
 	"github.com/orisano/gomasio/engineio"
@@ -18,28 +20,207 @@ func (f HandleFunc) HandleSocketIO(ctx Context) {
 	f(ctx)
 }
 
+type Options struct {
+	Logger     gomasio.Logger
+	Metrics    gomasio.Metrics
+	Tracer     Tracer
+	Propagator Propagator
+
+	OnDisconnect func(namespace, reason string)
+
+	DecoderOptions []DecoderOption
+}
+
+type Option func(o *Options)
+
+func WithLogger(l gomasio.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+func WithMetrics(m gomasio.Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = m
+	}
+}
+
+func WithTracer(t Tracer) Option {
+	return func(o *Options) {
+		o.Tracer = t
+	}
+}
+
+func WithPropagator(p Propagator) Option {
+	return func(o *Options) {
+		o.Propagator = p
+	}
+}
+
+// OnDisconnect registers f to be called when a namespace is disconnected by the server,
+// by Context.Disconnect or by the end of the connection.
+func OnDisconnect(f func(namespace, reason string)) Option {
+	return func(o *Options) {
+		o.OnDisconnect = f
+	}
+}
+
+// WithDecoderOptions configures the decoder of incoming packets, e.g. StrictDecoding or WithLimits.
+func WithDecoderOptions(opts ...DecoderOption) Option {
+	return func(o *Options) {
+		o.DecoderOptions = append(o.DecoderOptions, opts...)
+	}
+}
+
 type engineioHandler struct {
-	handler Handler
+	handler      Handler
+	logger       gomasio.Logger
+	config       *contextConfig
+	onDisconnect func(namespace, reason string)
+	decoderOpts  []DecoderOption
+}
+
+// HandleOpen starts the namespace and ack state of the connection. On engine.io protocol 4
+// (socket.io 3 and later) it joins the default namespace, which the server no longer connects implicitly.
+func (h *engineioHandler) HandleOpen(ctx stdctx.Context, wf gomasio.WriterFactory, session *engineio.Session) stdctx.Context {
+	ctx = stdctx.WithValue(ctx, connStateKey{}, newConnState(session.ID, h.onDisconnect))
+	if session.Version < 4 {
+		return ctx
+	}
+	// NewConnectPacket fails only to marshal auth.
+	p, _ := NewConnectPacket("/", nil)
+	w := wf.NewWriter()
+	if err := NewEncoder(w).Encode(p); err != nil {
+		h.logger.Error("encode socket.io connect", "sid", session.ID, "error", err)
+		return ctx
+	}
+	if err := w.Flush(); err != nil {
+		h.logger.Error("write socket.io connect", "sid", session.ID, "error", err)
+		return ctx
+	}
+	h.config.metrics.PacketSent("socket.io", CONNECT.String())
+	return ctx
+}
+
+// HandleInline resolves acks on the read goroutine, so that handlers waiting for them on a bounded
+// engineio.Dispatcher cannot keep their own acks from being handled.
+func (h *engineioHandler) HandleInline(ctx stdctx.Context, wf gomasio.WriterFactory, body []byte) bool {
+	if len(body) == 0 || body[0] != byte(ACK)+'0' {
+		return false
+	}
+	state := stateFrom(ctx)
+	if state == nil {
+		return false
+	}
+	p, err := NewDecoder(bytes.NewReader(body), h.decoderOpts...).Decode()
+	if err != nil {
+		return false
+	}
+	ok, err := state.acks.resolve(p.Namespace, p.ID, p.Body)
+	if err != nil {
+		h.logger.Warn("invalid socket.io ack", "sid", state.sid, "namespace", p.Namespace, "id", p.ID, "error", err)
+	}
+	if ok {
+		h.config.metrics.PacketReceived("socket.io", ACK.String())
+	}
+	return ok
+}
+
+// HandleClose disconnects the namespaces of the connection and fails its pending acks.
+func (h *engineioHandler) HandleClose(ctx stdctx.Context) {
+	if s := stateFrom(ctx); s != nil {
+		s.close()
+	}
 }
 
 func (h *engineioHandler) HandleMessage(wf gomasio.WriterFactory, body io.Reader) {
-	p, err := NewDecoder(body).Decode()
-	if err != nil && err != io.EOF {
+	h.HandleMessageContext(stdctx.Background(), wf, body)
+}
+
+func (h *engineioHandler) HandleMessageContext(ctx stdctx.Context, wf gomasio.WriterFactory, body io.Reader) {
+	state := stateFrom(ctx)
+	sid := sidFrom(state)
+	p, err := NewDecoder(body, h.decoderOpts...).Decode()
+	if err != nil {
+		h.logger.Warn("drop socket.io packet", "sid", sid, "error", err)
 		return
 	}
-	ctx, err := NewContext(wf, p)
+	h.config.metrics.PacketReceived("socket.io", p.Type.String())
+	if p.Type == ACK && state != nil {
+		ok, err := state.acks.resolve(p.Namespace, p.ID, p.Body)
+		if err != nil {
+			h.logger.Warn("invalid socket.io ack", "sid", sid, "namespace", p.Namespace, "id", p.ID, "error", err)
+		}
+		if ok {
+			return
+		}
+		h.logger.Debug("socket.io ack without waiter", "sid", sid, "namespace", p.Namespace, "id", p.ID)
+	}
+	switch {
+	case state == nil:
+	case p.Type == CONNECT:
+		state.connect(p.Namespace)
+	case p.Type == DISCONNECT:
+		h.logger.Info("socket.io namespace disconnected by server", "sid", sid, "namespace", p.Namespace)
+		state.disconnect(p.Namespace, ReasonServerDisconnect)
+	}
+	c, err := newContext(ctx, wf, p, h.config)
 	if err != nil {
+		h.logger.Warn("drop socket.io packet", "sid", sid, "namespace", p.Namespace, "type", p.Type.String(), "error", err)
 		return
 	}
-	h.handler.HandleSocketIO(ctx)
+	c.state = state
+	if c.err != nil {
+		h.logger.Warn("socket.io error received", "sid", sid, "namespace", p.Namespace, "message", c.err.Message)
+	}
+	span := Span(nopSpan{})
+	if p.Type == EVENT {
+		c.ctx, span = h.config.tracer.Start(c.ctx, SpanReceive, p.Namespace, c.event)
+	}
+	start := time.Now()
+	h.handler.HandleSocketIO(c)
+	h.config.metrics.HandlerDuration(time.Since(start))
+	span.End(nil)
 }
 
-func Connect(ctx stdctx.Context, conn gomasio.Conn, handler Handler) error {
-	return engineio.Connect(ctx, conn, OverEngineIO(handler))
+func Connect(ctx stdctx.Context, conn gomasio.Conn, handler Handler, opts ...engineio.Option) error {
+	var o engineio.Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var hopts []Option
+	if o.Logger != nil {
+		hopts = append(hopts, WithLogger(o.Logger))
+	}
+	if o.Metrics != nil {
+		hopts = append(hopts, WithMetrics(o.Metrics))
+	}
+	return engineio.Connect(ctx, conn, OverEngineIO(handler, hopts...), opts...)
 }
 
-func OverEngineIO(handler Handler) engineio.Handler {
-	return &engineioHandler{handler}
+// OverEngineIO returns an engine.io handler running handler for socket.io packets.
+// Namespace state and acks are kept per connection from HandleOpen to HandleClose, as engineio.Connect calls them;
+// without them, EmitWithAck fails with ErrAckUnsupported.
+func OverEngineIO(handler Handler, opts ...Option) engineio.Handler {
+	options := &Options{
+		Logger:  gomasio.NopLogger(),
+		Metrics: gomasio.NopMetrics(),
+		Tracer:  nopTracer{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &engineioHandler{
+		handler: handler,
+		logger:  options.Logger,
+		config: &contextConfig{
+			metrics:    options.Metrics,
+			tracer:     options.Tracer,
+			propagator: options.Propagator,
+		},
+		onDisconnect: options.OnDisconnect,
+		decoderOpts:  options.DecoderOptions,
+	}
 }
 
 type EventMux struct {
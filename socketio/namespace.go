@@ -0,0 +1,98 @@
+package socketio
+
+import (
+	stdctx "context"
+	"fmt"
+	"sync"
+)
+
+const (
+	ReasonServerDisconnect = "io server disconnect"
+	ReasonClientDisconnect = "io client disconnect"
+	ReasonTransportClose   = "transport close"
+)
+
+// connState is the namespace and ack state of a connection. It lives from HandleOpen to HandleClose.
+type connState struct {
+	sid          string
+	onDisconnect func(namespace, reason string)
+	acks         *ackRegistry
+
+	mu           sync.Mutex
+	connected    map[string]bool
+	disconnected map[string]string
+}
+
+func (s *connState) connect(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected[namespace] = true
+	delete(s.disconnected, namespace)
+}
+
+func (s *connState) disconnect(namespace, reason string) {
+	s.mu.Lock()
+	if _, ok := s.disconnected[namespace]; ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.connected, namespace)
+	s.disconnected[namespace] = reason
+	s.mu.Unlock()
+	s.acks.fail(namespace, disconnectedError(namespace, reason))
+	if s.onDisconnect != nil {
+		s.onDisconnect(namespace, reason)
+	}
+}
+
+func (s *connState) close() {
+	s.mu.Lock()
+	namespaces := make([]string, 0, len(s.connected))
+	for ns := range s.connected {
+		namespaces = append(namespaces, ns)
+	}
+	s.mu.Unlock()
+	for _, ns := range namespaces {
+		s.disconnect(ns, ReasonTransportClose)
+	}
+	s.acks.close(fmt.Errorf("connection closed(reason=%v): %w", ReasonTransportClose, ErrDisconnected))
+}
+
+func (s *connState) check(namespace string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reason, ok := s.disconnected[namespace]; ok {
+		return disconnectedError(namespace, reason)
+	}
+	return nil
+}
+
+func disconnectedError(namespace, reason string) error {
+	return fmt.Errorf("%v(reason=%v): %w", namespace, reason, ErrDisconnected)
+}
+
+func newConnState(sid string, onDisconnect func(namespace, reason string)) *connState {
+	return &connState{
+		sid:          sid,
+		onDisconnect: onDisconnect,
+		acks:         newAckRegistry(),
+		connected:    make(map[string]bool),
+		disconnected: make(map[string]string),
+	}
+}
+
+type connStateKey struct{}
+
+// sidFrom returns the engine.io session ID of the connection state, or "" without it.
+func sidFrom(s *connState) string {
+	if s == nil {
+		return ""
+	}
+	return s.sid
+}
+
+// stateFrom returns the connection state stored in ctx by HandleOpen.
+func stateFrom(ctx stdctx.Context) *connState {
+	s, _ := ctx.Value(connStateKey{}).(*connState)
+	return s
+}
@@ -0,0 +1,143 @@
+package socketio
+
+import (
+	"bytes"
+	stdctx "context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/orisano/gomasio"
+	"github.com/orisano/gomasio/engineio"
+)
+
+// testConn runs a handler for a connection as engineio.Connect does.
+type testConn struct {
+	h   engineio.Handler
+	wf  gomasio.WriterFactory
+	ctx stdctx.Context
+}
+
+func openTestConn(ctx stdctx.Context, h engineio.Handler, wf gomasio.WriterFactory) *testConn {
+	ctx = h.(engineio.OpenHandler).HandleOpen(ctx, wf, &engineio.Session{Version: 3})
+	return &testConn{h: h, wf: wf, ctx: ctx}
+}
+
+func (c *testConn) receive(body string) {
+	c.h.(engineio.ContextHandler).HandleMessageContext(c.ctx, c.wf, bytes.NewBufferString(body))
+}
+
+func (c *testConn) close() {
+	c.h.(engineio.CloseHandler).HandleClose(c.ctx)
+}
+
+func TestDisconnect(t *testing.T) {
+	var b bytes.Buffer
+	wf := &testWriterFactory{&b}
+	var reasons []string
+	var ctxs []Context
+	h := OverEngineIO(HandleFunc(func(ctx Context) {
+		ctxs = append(ctxs, ctx)
+	}), OnDisconnect(func(namespace, reason string) {
+		reasons = append(reasons, namespace+" "+reason)
+	}))
+	conn := openTestConn(stdctx.Background(), h, wf)
+	conn.receive("0/chat")
+	conn.receive("1/chat")
+
+	if len(ctxs) != 2 {
+		t.Fatalf("unexpected handled packets. expected: 2, but got: %v", len(ctxs))
+	}
+	if len(reasons) != 1 || reasons[0] != "/chat "+ReasonServerDisconnect {
+		t.Errorf("unexpected disconnect reasons: %v", reasons)
+	}
+	if err := ctxs[0].Emit("hello"); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("unexpected emit error. expected: %v, but got: %v", ErrDisconnected, err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("unexpected written: %v", b.String())
+	}
+
+	conn.receive("0/chat")
+	if err := ctxs[0].Emit("hello"); err != nil {
+		t.Errorf("unexpected emit error after reconnect: %v", err)
+	}
+
+	conn.close()
+	if len(reasons) != 2 || reasons[1] != "/chat "+ReasonTransportClose {
+		t.Errorf("unexpected disconnect reasons: %v", reasons)
+	}
+	if err := ctxs[0].Emit("hello"); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("unexpected emit error after close. expected: %v, but got: %v", ErrDisconnected, err)
+	}
+}
+
+func TestContext_Err(t *testing.T) {
+	ts := []struct {
+		body     string
+		expected string
+		data     string
+	}{
+		{
+			body:     `4/admin,"Not authorized"`,
+			expected: "Not authorized",
+		},
+		{
+			body:     `4/admin,{"message":"invalid token","data":{"code":1}}`,
+			expected: "invalid token",
+			data:     `{"code":1}`,
+		},
+	}
+	for _, tc := range ts {
+		var got *ServerError
+		h := OverEngineIO(HandleFunc(func(ctx Context) {
+			if !errors.As(Err(ctx), &got) {
+				t.Errorf("unexpected error: %v", Err(ctx))
+			}
+		}))
+		h.HandleMessage(&testWriterFactory{new(bytes.Buffer)}, bytes.NewBufferString(tc.body))
+		if got == nil {
+			continue
+		}
+		if got.Namespace != "/admin" || got.Message != tc.expected || string(got.Data) != tc.data {
+			t.Errorf("unexpected server error: %+v", got)
+		}
+	}
+}
+
+func TestHandleOpen(t *testing.T) {
+	ts := []struct {
+		version  int
+		expected string
+	}{
+		{version: 3, expected: ""},
+		{version: 4, expected: "0"},
+	}
+	for _, tc := range ts {
+		var b bytes.Buffer
+		h := OverEngineIO(HandleFunc(func(Context) {}))
+		h.(engineio.OpenHandler).HandleOpen(stdctx.Background(), &testWriterFactory{&b}, &engineio.Session{Version: tc.version})
+		if got := b.String(); got != tc.expected {
+			t.Errorf("unexpected written of version %v. expected: %q, but got: %q", tc.version, tc.expected, got)
+		}
+	}
+}
+
+func TestHandler_LogSessionID(t *testing.T) {
+	var logs bytes.Buffer
+	h := OverEngineIO(HandleFunc(func(Context) {}), WithLogger(gomasio.StdLogger(log.New(&logs, "", 0))))
+	wf := &testWriterFactory{new(bytes.Buffer)}
+	ctx := h.(engineio.OpenHandler).HandleOpen(stdctx.Background(), wf, &engineio.Session{ID: "abc", Version: 3})
+	conn := &testConn{h: h, wf: wf, ctx: ctx}
+	conn.receive("1/chat")
+	conn.receive("x")
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if !strings.Contains(line, "sid=abc") {
+			t.Errorf("log without sid: %v", line)
+		}
+	}
+	if got := strings.Count(logs.String(), "\n"); got != 2 {
+		t.Errorf("unexpected log lines. expected: 2, but got: %v", got)
+	}
+}
@@ -1,7 +1,14 @@
 package socketio
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/orisano/gomasio/internal/peek"
 )
 
 type PacketType int
@@ -16,6 +23,15 @@ const (
 	BINARY_ACK
 )
 
+var packetTypeNames = [...]string{"CONNECT", "DISCONNECT", "EVENT", "ACK", "ERROR", "BINARY_EVENT", "BINARY_ACK"}
+
+func (t PacketType) String() string {
+	if t < 0 || int(t) >= len(packetTypeNames) {
+		return "INVALID"
+	}
+	return packetTypeNames[t]
+}
+
 type Packet struct {
 	Type        PacketType
 	Attachments int
@@ -23,3 +39,216 @@ type Packet struct {
 	ID          int
 	Body        io.Reader
 }
+
+// NewConnectPacket returns a CONNECT packet of namespace. auth is the JSON payload sent
+// by socket.io 3 and later, and no payload is sent if it is nil.
+func NewConnectPacket(namespace string, auth interface{}) (*Packet, error) {
+	p := newPacket(CONNECT, namespace, -1)
+	if auth == nil {
+		return p, nil
+	}
+	b, err := marshalValue(auth)
+	if err != nil {
+		return nil, fmt.Errorf("marshal auth: %w", err)
+	}
+	p.Body = bytes.NewReader(b)
+	return p, nil
+}
+
+func NewDisconnectPacket(namespace string) *Packet {
+	return newPacket(DISCONNECT, namespace, -1)
+}
+
+// NewEventPacket returns an EVENT packet without ack. Set ID to request an ack.
+func NewEventPacket(namespace, name string, args ...interface{}) (*Packet, error) {
+	b, err := marshalArray(name, args)
+	if err != nil {
+		return nil, err
+	}
+	p := newPacket(EVENT, namespace, -1)
+	p.Body = bytes.NewReader(b)
+	return p, nil
+}
+
+func NewAckPacket(namespace string, id int, args ...interface{}) (*Packet, error) {
+	b, err := marshalArray(nil, args)
+	if err != nil {
+		return nil, err
+	}
+	p := newPacket(ACK, namespace, id)
+	p.Body = bytes.NewReader(b)
+	return p, nil
+}
+
+// NewErrorPacket returns an ERROR packet. data is a message string (socket.io 2.x)
+// or an object such as {"message": "not authorized"} (socket.io 3 and later).
+func NewErrorPacket(namespace string, data interface{}) (*Packet, error) {
+	b, err := marshalValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+	p := newPacket(ERROR, namespace, -1)
+	p.Body = bytes.NewReader(b)
+	return p, nil
+}
+
+// NewBinaryEventPacket returns a BINARY_EVENT packet without ack and its attachments.
+// Arguments of type []byte are replaced by placeholders and returned as the attachments,
+// which are sent as binary messages following the packet.
+func NewBinaryEventPacket(namespace, name string, args ...interface{}) (*Packet, [][]byte, error) {
+	args, attachments := extractAttachments(args)
+	b, err := marshalArray(name, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := newPacket(BINARY_EVENT, namespace, -1)
+	p.Attachments = len(attachments)
+	p.Body = bytes.NewReader(b)
+	return p, attachments, nil
+}
+
+// NewBinaryAckPacket is the ack version of NewBinaryEventPacket.
+func NewBinaryAckPacket(namespace string, id int, args ...interface{}) (*Packet, [][]byte, error) {
+	args, attachments := extractAttachments(args)
+	b, err := marshalArray(nil, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := newPacket(BINARY_ACK, namespace, id)
+	p.Attachments = len(attachments)
+	p.Body = bytes.NewReader(b)
+	return p, attachments, nil
+}
+
+func newPacket(t PacketType, namespace string, id int) *Packet {
+	if namespace == "" {
+		namespace = "/"
+	}
+	return &Packet{
+		Type:        t,
+		Attachments: -1,
+		Namespace:   namespace,
+		ID:          id,
+	}
+}
+
+type placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+func extractAttachments(args []interface{}) ([]interface{}, [][]byte) {
+	replaced := make([]interface{}, len(args))
+	attachments := [][]byte{}
+	for i, arg := range args {
+		b, ok := arg.([]byte)
+		if !ok {
+			replaced[i] = arg
+			continue
+		}
+		replaced[i] = placeholder{Placeholder: true, Num: len(attachments)}
+		attachments = append(attachments, b)
+	}
+	return replaced, attachments
+}
+
+func marshalValue(v interface{}) ([]byte, error) {
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+	if err := buf.encodeValue(v); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// marshalArray marshals args into a JSON array, headed by name unless it is nil.
+func marshalArray(name interface{}, args []interface{}) ([]byte, error) {
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+	buf.WriteByte('[')
+	if name != nil {
+		if err := buf.encodeValue(name); err != nil {
+			return nil, fmt.Errorf("marshal event name: %w", err)
+		}
+	}
+	for i, arg := range args {
+		if i > 0 || name != nil {
+			buf.WriteByte(',')
+		}
+		if err := buf.encodeValue(arg); err != nil {
+			return nil, fmt.Errorf("marshal args: %w", err)
+		}
+	}
+	buf.WriteByte(']')
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// ParsePacket parses s encoded by Encoder.Encode, e.g. `2/chat,12["hello"]`.
+// The body of the returned packet is read, so that it can be formatted and read again.
+func ParsePacket(s string) (*Packet, error) {
+	p, err := NewDecoder(strings.NewReader(s)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(p.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	p.Body = bytes.NewReader(b)
+	return p, nil
+}
+
+// String returns the packet type, the namespace, the attachments and the ID if any, and the body,
+// e.g. `EVENT /chat id=12 ["hello"]`. The body is shown only if it can be read without consuming it,
+// as the bodies of ParsePacket and the constructors can.
+func (p *Packet) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.Type.String())
+	sb.WriteByte(' ')
+	if p.Namespace == "" {
+		sb.WriteByte('/')
+	} else {
+		sb.WriteString(p.Namespace)
+	}
+	if p.Attachments >= 0 && (p.Type == BINARY_EVENT || p.Type == BINARY_ACK) {
+		sb.WriteString(" attachments=")
+		sb.WriteString(strconv.Itoa(p.Attachments))
+	}
+	if p.ID >= 0 {
+		sb.WriteString(" id=")
+		sb.WriteString(strconv.Itoa(p.ID))
+	}
+	if p.Body == nil {
+		return sb.String()
+	}
+	b, ok := peek.Bytes(p.Body)
+	if !ok {
+		sb.WriteString(" (unread body)")
+		return sb.String()
+	}
+	if b = bytes.TrimSpace(b); len(b) > 0 {
+		sb.WriteByte(' ')
+		sb.Write(b)
+	}
+	return sb.String()
+}
+
+// Format implements fmt.Formatter. %+v prints the fields, and the other verbs print String.
+func (p *Packet) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		body := "nil"
+		if p.Body != nil {
+			if b, ok := peek.Bytes(p.Body); ok {
+				body = fmt.Sprintf("%q", b)
+			} else {
+				body = "(unread)"
+			}
+		}
+		fmt.Fprintf(f, "{Type:%v Attachments:%v Namespace:%v ID:%v Body:%v}", p.Type, p.Attachments, p.Namespace, p.ID, body)
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", p.String())
+	default:
+		io.WriteString(f, p.String())
+	}
+}
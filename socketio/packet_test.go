@@ -0,0 +1,75 @@
+package socketio
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestParsePacket(t *testing.T) {
+	must := func(p *Packet, err error) *Packet {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	binary, attachments, err := NewBinaryEventPacket("/chat", "upload", "a.txt", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || string(attachments[0]) != "hello" {
+		t.Errorf("unexpected attachments: %q", attachments)
+	}
+	binaryAck, _, err := NewBinaryAckPacket("/", 3, []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := must(NewEventPacket("/chat", "ping", 1))
+	ack.ID = 12
+	ts := []struct {
+		packet   *Packet
+		encoded  string
+		expected string
+	}{
+		{packet: must(NewConnectPacket("/", nil)), encoded: "0", expected: "CONNECT /"},
+		{packet: must(NewConnectPacket("/admin", map[string]string{"token": "x"})), encoded: `0/admin,{"token":"x"}`, expected: `CONNECT /admin {"token":"x"}`},
+		{packet: NewDisconnectPacket("/chat"), encoded: "1/chat,", expected: "DISCONNECT /chat"},
+		{packet: must(NewEventPacket("", "hello")), encoded: `2["hello"]`, expected: `EVENT / ["hello"]`},
+		{packet: ack, encoded: `2/chat,12["ping",1]`, expected: `EVENT /chat id=12 ["ping",1]`},
+		{packet: must(NewAckPacket("/", 12, "pong")), encoded: `312["pong"]`, expected: `ACK / id=12 ["pong"]`},
+		{packet: must(NewAckPacket("/", 0)), encoded: `30[]`, expected: `ACK / id=0 []`},
+		{packet: must(NewErrorPacket("/", map[string]string{"message": "not authorized"})), encoded: `4{"message":"not authorized"}`, expected: `ERROR / {"message":"not authorized"}`},
+		{packet: binary, encoded: `51-/chat,["upload","a.txt",{"_placeholder":true,"num":0}]`, expected: `BINARY_EVENT /chat attachments=1 ["upload","a.txt",{"_placeholder":true,"num":0}]`},
+		{packet: binaryAck, encoded: `61-3[{"_placeholder":true,"num":0}]`, expected: `BINARY_ACK / attachments=1 id=3 [{"_placeholder":true,"num":0}]`},
+	}
+	for _, tc := range ts {
+		if got := tc.packet.String(); got != tc.expected {
+			t.Errorf("unexpected string. expected: %v, but got: %v", tc.expected, got)
+		}
+		var b bytes.Buffer
+		if err := NewEncoder(&b).Encode(tc.packet); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tc.encoded {
+			t.Errorf("unexpected encoded packet. expected: %v, but got: %v", tc.encoded, b.String())
+		}
+		p, err := ParsePacket(b.String())
+		if err != nil {
+			t.Fatalf("parse %q: %v", b.String(), err)
+		}
+		if got := fmt.Sprint(p); got != tc.expected {
+			t.Errorf("unexpected parsed packet. expected: %v, but got: %v", tc.expected, got)
+		}
+	}
+}
+
+func TestPacket_Format(t *testing.T) {
+	p := NewDisconnectPacket("/chat")
+	if got, expected := fmt.Sprintf("%+v", p), "{Type:DISCONNECT Attachments:-1 Namespace:/chat ID:-1 Body:nil}"; got != expected {
+		t.Errorf("unexpected format. expected: %v, but got: %v", expected, got)
+	}
+	if got, expected := fmt.Sprintf("%q", p), `"DISCONNECT /chat"`; got != expected {
+		t.Errorf("unexpected format. expected: %v, but got: %v", expected, got)
+	}
+}
@@ -0,0 +1,67 @@
+package socketio
+
+import (
+	stdctx "context"
+	"encoding/json"
+)
+
+type SpanKind int
+
+const (
+	SpanReceive SpanKind = iota
+	SpanEmit
+)
+
+type Span interface {
+	End(err error)
+}
+
+// Tracer starts a span per received event and per emit (until the ack arrives for EmitWithAck).
+type Tracer interface {
+	Start(ctx stdctx.Context, kind SpanKind, namespace, event string) (stdctx.Context, Span)
+}
+
+// Propagator carries trace context in event metadata.
+type Propagator interface {
+	Inject(ctx stdctx.Context, carrier map[string]string)
+	Extract(ctx stdctx.Context, carrier map[string]string) stdctx.Context
+}
+
+// MetadataKey is the key of the trailing argument object that holds event metadata,
+// e.g. ["message","hello",{"__meta":{"traceparent":"..."}}].
+const MetadataKey = "__meta"
+
+type nopSpan struct{}
+
+func (nopSpan) End(err error) {}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx stdctx.Context, kind SpanKind, namespace, event string) (stdctx.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+// metadata returns the trailing argument that carries carrier.
+func metadata(carrier map[string]string) interface{} {
+	return map[string]map[string]string{MetadataKey: carrier}
+}
+
+// splitMetadata removes the trailing metadata argument from args if present.
+func splitMetadata(args []json.RawMessage) ([]json.RawMessage, map[string]string) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	last := args[len(args)-1]
+	if len(last) == 0 || last[0] != '{' {
+		return args, nil
+	}
+	var m map[string]map[string]string
+	if err := json.Unmarshal(last, &m); err != nil || len(m) != 1 {
+		return args, nil
+	}
+	carrier, ok := m[MetadataKey]
+	if !ok {
+		return args, nil
+	}
+	return args[:len(args)-1], carrier
+}
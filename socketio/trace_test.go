@@ -0,0 +1,44 @@
+package socketio
+
+import (
+	"bytes"
+	stdctx "context"
+	"testing"
+)
+
+type testKey struct{}
+
+type testPropagator struct{}
+
+func (testPropagator) Inject(ctx stdctx.Context, carrier map[string]string) {
+	if v, ok := ctx.Value(testKey{}).(string); ok {
+		carrier["trace"] = v
+	}
+}
+
+func (testPropagator) Extract(ctx stdctx.Context, carrier map[string]string) stdctx.Context {
+	return stdctx.WithValue(ctx, testKey{}, carrier["trace"])
+}
+
+func TestPropagator(t *testing.T) {
+	var b bytes.Buffer
+	wf := &testWriterFactory{&b}
+	h := OverEngineIO(HandleFunc(func(ctx Context) {
+		if got := StdContext(ctx).Value(testKey{}); got != "abc" {
+			t.Errorf("unexpected trace. expected: abc, but got: %v", got)
+		}
+		var s string
+		if err := ctx.Args(&s); err != nil {
+			t.Error(err)
+		}
+		if err := ctx.Emit("reply", s); err != nil {
+			t.Error(err)
+		}
+	}), WithPropagator(testPropagator{}))
+	h.HandleMessage(wf, bytes.NewBufferString(`2["hello","world",{"__meta":{"trace":"abc"}}]`))
+
+	expected := `2["reply","world",{"__meta":{"trace":"abc"}}]` + "\n"
+	if got := b.String(); got != expected {
+		t.Errorf("unexpected emit event. expected: %v, but got: %v", expected, got)
+	}
+}
@@ -1,14 +1,18 @@
 package gomasio
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/url"
+	"strings"
 )
 
 type URLOptions struct {
 	Scheme string
 	Path   string
 	Query  url.Values
+	// Deprecated: Logger is not used. Use WithLogger on NewConn instead.
 	Logger *log.Logger
 }
 
@@ -29,6 +33,20 @@ func GetURL(host string, opts ...URLOption) (*url.URL, error) {
 		opt(options)
 	}
 
+	return buildURL(host, options)
+}
+
+func buildURL(host string, options *URLOptions) (*url.URL, error) {
+	if err := validateHost(host); err != nil {
+		return nil, err
+	}
+	if options.Scheme != "ws" && options.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported scheme: %q", options.Scheme)
+	}
+	if !strings.HasPrefix(options.Path, "/") {
+		return nil, fmt.Errorf("path must be absolute: %q", options.Path)
+	}
+
 	u := new(url.URL)
 	u.Host = host
 	u.Scheme = options.Scheme
@@ -38,6 +56,88 @@ func GetURL(host string, opts ...URLOption) (*url.URL, error) {
 	return u, nil
 }
 
+func validateHost(host string) error {
+	if host == "" {
+		return errors.New("missing host")
+	}
+	if strings.ContainsAny(host, "/?#@ ") {
+		return fmt.Errorf("invalid host: %q", host)
+	}
+	u, err := url.Parse("//" + host)
+	if err != nil {
+		return fmt.Errorf("invalid host: %w", err)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid host: %q", host)
+	}
+	return nil
+}
+
+// ParseURL parses a socket.io server URL such as "https://example.com/admin?token=x"
+// the same way as the JavaScript client: http and https are mapped to ws and wss,
+// the path is the namespace and the query is forwarded to the server.
+// The engine.io path defaults to /socket.io/ and can be changed by WithPath.
+func ParseURL(rawurl string, opts ...URLOption) (*url.URL, string, error) {
+	if rawurl == "" {
+		return nil, "", errors.New("empty url")
+	}
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	src, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse url: %w", err)
+	}
+	if src.User != nil {
+		return nil, "", errors.New("userinfo is not supported")
+	}
+
+	options := &URLOptions{
+		Path:  "/socket.io/",
+		Query: src.Query(),
+	}
+	switch src.Scheme {
+	case "http", "ws":
+		options.Scheme = "ws"
+	case "https", "wss":
+		options.Scheme = "wss"
+	default:
+		return nil, "", fmt.Errorf("unsupported scheme: %q", src.Scheme)
+	}
+	if options.Query.Get("EIO") == "" {
+		options.Query.Set("EIO", "3")
+	}
+	options.Query.Set("transport", "websocket")
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	u, err := buildURL(src.Host, options)
+	if err != nil {
+		return nil, "", err
+	}
+	namespace := src.Path
+	if namespace == "" {
+		namespace = "/"
+	}
+	return u, namespace, nil
+}
+
+// Dial parses rawurl by ParseURL with uopts and connects to it with opts.
+// It returns the connection and the namespace given by the URL path.
+func Dial(rawurl string, uopts []URLOption, opts ...ConnOption) (Conn, string, error) {
+	u, namespace, err := ParseURL(rawurl, uopts...)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := NewConn(u.String(), opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	return conn, namespace, nil
+}
+
 func WithSecure(o *URLOptions) {
 	o.Scheme = "wss"
 }
@@ -0,0 +1,80 @@
+package gomasio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersioner is implemented by connections that know the engine.io protocol version of the session.
+type ProtocolVersioner interface {
+	// ProtocolVersion returns the engine.io protocol version (3 or 4), or 0 if it is unknown.
+	ProtocolVersion() int
+}
+
+// ProtocolVersion returns the engine.io protocol version of c. It returns 0 if c is not a ProtocolVersioner.
+func ProtocolVersion(c Conn) int {
+	if v, ok := c.(ProtocolVersioner); ok {
+		return v.ProtocolVersion()
+	}
+	return 0
+}
+
+// queryVersion returns the EIO query parameter of urlStr, or 0 if it is missing or invalid.
+func queryVersion(urlStr string) int {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.Atoi(u.Query().Get("EIO"))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func withVersion(urlStr string, version int) (string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", fmt.Errorf("parse url: %w", err)
+	}
+	q := u.Query()
+	q.Set("EIO", strconv.Itoa(version))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// checkOpen checks that b is an OPEN packet. Its content does not tell the protocol version,
+// as engine.io servers before 6.1 do not announce maxPayload even on protocol 4.
+func checkOpen(b []byte) error {
+	if len(b) == 0 || b[0] != '0' {
+		return fmt.Errorf("missing OPEN packet: %q", b)
+	}
+	var session map[string]json.RawMessage
+	if err := json.Unmarshal(b[1:], &session); err != nil {
+		return fmt.Errorf("invalid session json: %w", err)
+	}
+	return nil
+}
+
+// readOpen reads the first message of ws, which is the OPEN packet.
+func readOpen(ctx context.Context, ws *websocket.Conn) (b []byte, err error) {
+	stop := interruptRead(ctx, ws)
+	defer func() {
+		if serr := stop(); serr != nil {
+			err = fmt.Errorf("read OPEN packet: %w", serr)
+		}
+	}()
+	mt, b, err := ws.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("read OPEN packet: %w", err)
+	}
+	if mt != websocket.TextMessage {
+		return nil, fmt.Errorf("unexpected OPEN message type: %v", mt)
+	}
+	return b, nil
+}
@@ -0,0 +1,71 @@
+package gomasio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNewConn_AutoVersion(t *testing.T) {
+	const (
+		handshakeV3 = `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":5000}`
+		handshakeV4 = `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}`
+		// engine.io 4 and 5 (socket.io 3.x to 4.0) do not announce maxPayload.
+		handshakeV4NoMaxPayload = `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`
+	)
+	ts := []struct {
+		name     string
+		accept   func(eio string) bool
+		close    func(eio string) bool
+		open     string
+		expected int
+	}{
+		{name: "v4", accept: func(string) bool { return true }, open: handshakeV4, expected: 4},
+		{name: "v4 without maxPayload", accept: func(string) bool { return true }, open: handshakeV4NoMaxPayload, expected: 4},
+		{name: "rejected", accept: func(eio string) bool { return eio == "3" }, open: handshakeV3, expected: 3},
+		{name: "closed", accept: func(string) bool { return true }, close: func(eio string) bool { return eio == "4" }, open: handshakeV3, expected: 3},
+	}
+	upgrader := websocket.Upgrader{}
+	for _, tc := range ts {
+		t.Run(tc.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tc.accept(r.URL.Query().Get("EIO")) {
+					http.Error(w, `{"code":5,"message":"Unsupported protocol version"}`, http.StatusBadRequest)
+					return
+				}
+				ws, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer ws.Close()
+				if tc.close != nil && tc.close(r.URL.Query().Get("EIO")) {
+					return
+				}
+				ws.WriteMessage(websocket.TextMessage, []byte(tc.open))
+				ws.ReadMessage()
+			}))
+			defer s.Close()
+
+			u, _ := GetURL(strings.TrimPrefix(s.URL, "http://"))
+			conn, err := NewConn(u.String(), WithAutoVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if got := ProtocolVersion(conn); got != tc.expected {
+				t.Errorf("unexpected protocol version. expected: %v, but got: %v", tc.expected, got)
+			}
+			r, err := conn.NextReader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := ioutil.ReadAll(r); string(b) != tc.open {
+				t.Errorf("unexpected OPEN packet. expected: %v, but got: %s", tc.open, b)
+			}
+		})
+	}
+}
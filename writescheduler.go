@@ -0,0 +1,121 @@
+package gomasio
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WriteScheduler writes the queued messages of many connections on a fixed number of goroutines.
+// Messages of a connection are written in order by one goroutine at a time.
+// A slow connection occupies a goroutine while it is written, up to the write timeout of the connection,
+// so use enough workers for the slowest peers. Scheduling a connection never blocks.
+type WriteScheduler struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	head   *conn
+	tail   *conn
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewWriteScheduler starts workers goroutines writing the connections given by WithWriteScheduler.
+func NewWriteScheduler(workers int) *WriteScheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+	s := newWriteScheduler()
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+func newWriteScheduler() *WriteScheduler {
+	s := &WriteScheduler{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Close stops the workers. Messages queued after that are never written,
+// so close the connections using s first.
+func (s *WriteScheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// schedule appends c to the ready list unless it is already there or being written.
+func (s *WriteScheduler) schedule(c *conn) {
+	if !atomic.CompareAndSwapInt32(&c.scheduled, 0, 1) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.tail == nil {
+		s.head = c
+	} else {
+		s.tail.next = c
+	}
+	s.tail = c
+	s.cond.Signal()
+}
+
+// next waits for a ready connection. It returns nil after Close.
+func (s *WriteScheduler) next() *conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.head == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil
+	}
+	c := s.head
+	s.head = c.next
+	if s.head == nil {
+		s.tail = nil
+	}
+	c.next = nil
+	return c
+}
+
+func (s *WriteScheduler) work() {
+	defer s.wg.Done()
+	for {
+		c := s.next()
+		if c == nil {
+			return
+		}
+		c.drain()
+	}
+}
+
+// drain writes the queued messages until the queue is empty.
+func (c *conn) drain() {
+	for {
+		for n := len(c.wch); n > 0; n-- {
+			select {
+			case m := <-c.wch:
+				if err := c.write(m); err != nil {
+					c.logger.Error("write websocket message", "error", err)
+					c.closeWithError(err)
+					return
+				}
+			case <-c.done:
+				return
+			}
+		}
+		c.reportQueueDepth()
+		atomic.StoreInt32(&c.scheduled, 0)
+		// A message queued after the queue was found empty may have failed to schedule c.
+		if len(c.wch) == 0 || !atomic.CompareAndSwapInt32(&c.scheduled, 0, 1) {
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package gomasio
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWriteScheduler(t *testing.T) {
+	const conns, messages = 20, 50
+
+	upgrader := websocket.Upgrader{}
+	var wg sync.WaitGroup
+	wg.Add(conns)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		defer wg.Done()
+		for i := 0; i < messages; i++ {
+			_, b, err := ws.ReadMessage()
+			if err != nil {
+				t.Errorf("read message: %v", err)
+				return
+			}
+			if got, expected := string(b), strconv.Itoa(i); got != expected {
+				t.Errorf("unexpected message order. expected: %v, but got: %v", expected, got)
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s := NewWriteScheduler(2)
+	defer s.Close()
+	u, _ := GetURL(strings.TrimPrefix(ts.URL, "http://"))
+	for i := 0; i < conns; i++ {
+		conn, err := NewConn(u.String(), WithWriteScheduler(s), WithQueueSize(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		go func() {
+			for i := 0; i < messages; i++ {
+				w := conn.NewWriter()
+				fmt.Fprint(w, i)
+				if err := w.Flush(); err != nil {
+					t.Errorf("flush: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWriteScheduler_ScheduleNonBlocking(t *testing.T) {
+	// No worker takes the connections, as if all of them were stuck writing to slow peers.
+	s := newWriteScheduler()
+	const conns = 10000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < conns; i++ {
+			c := &conn{wch: make(chan outMessage, 1), done: make(chan struct{})}
+			s.schedule(c)
+			s.schedule(c)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule blocked")
+	}
+	n := 0
+	for c := s.head; c != nil; c = c.next {
+		n++
+	}
+	if n != conns {
+		t.Errorf("unexpected ready connections. expected: %v, but got: %v", conns, n)
+	}
+	s.Close()
+}
+
+func TestConn_WriteTimeout(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		// The peer never reads, so the writes of the client stall once the socket buffers are full.
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	s := NewWriteScheduler(1)
+	defer s.Close()
+	u, _ := GetURL(strings.TrimPrefix(ts.URL, "http://"))
+	conn, err := NewConn(u.String(), WithWriteScheduler(s), WithWriteTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := strings.Repeat("x", 1<<20)
+	go func() {
+		for {
+			w := conn.NewWriter()
+			io.WriteString(w, payload)
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-Done(conn):
+	case <-time.After(10 * time.Second):
+		t.Fatal("stalled write did not time out")
+	}
+}